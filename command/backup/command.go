@@ -69,7 +69,7 @@ Optional:
     -scheme         "http" or "https" (default: %s) 
     -host           Managment Server hostname with port (default: %s)
     -path           Managment Server api path (default: %s)
-    -format         Backup format (currently only "json" is supported)
+    -format         Backup format, one of %s, case insensitive, the built in "json" written indented (default: json)
     -output         File to write backup to (default: echo to stdout)
     -db-host        Database host to add to output (default: %s)
     -db-port        Database port to add to output (default: %d)
@@ -83,6 +83,7 @@ Optional:
 		definition.DefaultScheme,
 		definition.DefaultHost,
 		definition.DefaultPath,
+		strings.Join(definition.Formats(), ", "),
 		definition.DefaultDBHost,
 		definition.DefaultDBPort,
 		strings.Join(definition.DefaultFetchers(), ","))
@@ -127,28 +128,26 @@ func (c Command) Run(args []string) int {
 
 	c.log.Println("[info] Definition built")
 
-	if c.conf.format == "json" {
-		b, err := definition.FormatJSONIndent(zd)
-		if err != nil {
-			if ml, ok := c.log.(command.MutableLogger); ok {
-				ml.UnMute()
-			}
-			c.log.Printf("[error] Error formatting: %s", err)
-			return 1
+	b, err := definition.Format(zd, c.conf.format)
+	if err != nil {
+		if ml, ok := c.log.(command.MutableLogger); ok {
+			ml.UnMute()
 		}
-		if c.conf.output == "" {
-			fmt.Println(string(b))
+		c.log.Printf("[error] Error formatting: %s", err)
+		return 1
+	}
+	if c.conf.output == "" {
+		fmt.Println(string(b))
+	} else {
+		if f, err := os.Create(c.conf.output); err != nil {
+			c.log.Printf("[error] Error opening \"%s\": %s", c.conf.output, err)
+			return 1
+		} else if _, err = f.Write(b); err != nil {
+			c.log.Printf("[error] Error writing to \"%s\": %s", c.conf.output, err)
+			return 1
 		} else {
-			if f, err := os.Create(c.conf.output); err != nil {
-				c.log.Printf("[error] Error opening \"%s\": %s", c.conf.output, err)
-				return 1
-			} else if _, err = f.Write(b); err != nil {
-				c.log.Printf("[error] Error writing to \"%s\": %s", c.conf.output, err)
-				return 1
-			} else {
-				c.log.Printf("[info] Definition written to file \"%s\"", c.conf.output)
-				f.Close()
-			}
+			c.log.Printf("[info] Definition written to file \"%s\"", c.conf.output)
+			f.Close()
 		}
 	}
 
@@ -171,7 +170,7 @@ func (c Command) parseFlags(args []string) error {
 	fs.StringVar(&c.conf.hostPath, "path", definition.DefaultPath, "API path")
 	fs.StringVar(&c.conf.zoneID, "zone-id", "", "ID of Zone to clone (mutually exclusive with zone-name)")
 	fs.StringVar(&c.conf.zoneName, "zone-name", "", "Name of Zone to clone (mutually exclusive with zone-id)")
-	fs.StringVar(&c.conf.format, "format", "json", "Currently supports JSON")
+	fs.StringVar(&c.conf.format, "format", "json", "Backup format")
 	fs.StringVar(&c.conf.output, "output", "", "File to write to")
 
 	fs.StringVar(&c.conf.dbHost, "db-server", definition.DefaultDBHost, "Database host")
@@ -213,9 +212,26 @@ func (c Command) parseFlags(args []string) error {
 		c.log.Println("[error] zone-id or zone-name must be set")
 		configOK = false
 	}
-	c.conf.format = strings.ToLower(c.conf.format)
-	if c.conf.format != "json" {
-		c.log.Println("[error] format must be json")
+	// formats are looked up by the name given, then case insensitively, as formatters may be registered in any case
+	formatOK := false
+	for _, format := range []string{c.conf.format, strings.ToLower(c.conf.format)} {
+		for _, name := range definition.Formats() {
+			if format == name {
+				c.conf.format = format
+				formatOK = true
+				break
+			}
+		}
+		if formatOK {
+			break
+		}
+	}
+	// backups have always been indented, so the built in "json" stays an alias of "json-indent" here
+	if c.conf.format == "json" && definition.IsBuiltinFormatter("json") {
+		c.conf.format = "json-indent"
+	}
+	if !formatOK {
+		c.log.Printf("[error] format must be one of: %s", strings.Join(definition.Formats(), ", "))
 		configOK = false
 	}
 
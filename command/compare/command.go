@@ -0,0 +1,284 @@
+package compare
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/dcarbone/cs-zone-cloner/command"
+	"github.com/dcarbone/cs-zone-cloner/command/diff"
+	"github.com/dcarbone/cs-zone-cloner/definition"
+	"path"
+	"strings"
+)
+
+type server struct {
+	apiKey    string
+	apiSecret string
+
+	hostScheme string
+	hostAddr   string
+	hostPath   string
+
+	zoneID   string
+	zoneName string
+}
+
+type config struct {
+	zone  server
+	other server
+
+	format    string
+	ignore    string
+	allFields bool
+
+	fetch    string
+	fetchers []definition.Fetcher
+
+	options definition.DiffOptions
+}
+
+type Command struct {
+	self string
+	log  command.Logger
+	conf *config
+}
+
+func New(self string, log command.Logger) *Command {
+	c := &Command{
+		self: self,
+		log:  log,
+		conf: new(config),
+	}
+	return c
+}
+
+func (Command) Synopsis() string {
+	return "Compare the configuration of two live Zones"
+}
+
+func (c Command) Help() string {
+	return fmt.Sprintf(`Usage: %s compare [options]
+    Report the configuration differences between two live Zones, which may be on different Management Servers.
+    Values which necessarily differ between zones are not compared (%s).
+    Pods, clusters, hosts and primary storage pools, which are named per zone, are compared as a summary of the
+    hypervisors, host tags and storage tags of each zone.
+
+Required:
+    -key            API key
+    -secret         API secret
+    -zone-name      Name of the first Zone.  Mutually exclusive with "zone-id"
+    -zone-id        ID of the first Zone.  Mutually exclusive with "zone-name"
+    -other-zone-name
+                    Name of the second Zone.  Mutually exclusive with "other-zone-id"
+    -other-zone-id  ID of the second Zone.  Mutually exclusive with "other-zone-name"
+
+Optional:
+    -scheme         "http" or "https" (default: %s)
+    -host           Managment Server hostname with port (default: %s)
+    -path           Managment Server api path (default: %s)
+    -other-key      API key for the second Zone (default: -key)
+    -other-secret   API secret for the second Zone (default: -secret)
+    -other-scheme   "http" or "https" for the second Zone (default: -scheme)
+    -other-host     Managment Server hostname with port for the second Zone (default: -host)
+    -other-path     Managment Server api path for the second Zone (default: -path)
+    -fetch          Comma-separated list of fetchers to execute against both Zones (default: %s)
+                    Available: %s
+    -format         "text" or "json" (default: text)
+    -ignore         Comma-separated glob patterns of additional field names to leave out of the comparison
+    -all-fields     Compare the volatile fields that are otherwise ignored (%s)
+
+`,
+		c.self,
+		strings.Join(definition.ZoneSpecificFields, ","),
+		definition.DefaultScheme,
+		definition.DefaultHost,
+		definition.DefaultPath,
+		strings.Join(definition.CompareFetchers, ","),
+		strings.Join(definition.DefaultFetchers(), ","),
+		strings.Join(definition.DefaultVolatileFields, ","))
+}
+
+func (c *Command) Run(args []string) int {
+	var err error
+
+	if err = c.parseFlags(args); err != nil {
+		c.log.Printf("[error] Setup failed: %s", err)
+		return 1
+	}
+
+	definition.SetPackageLogger(c.log)
+
+	diffs, err := definition.Compare(c.defConf(c.conf.zone), c.defConf(c.conf.other), c.conf.options)
+	if err != nil {
+		c.log.Printf("[error] Execution failed: %s", err)
+		return 1
+	}
+
+	if err = diff.PrintDiff(diffs, c.conf.format); err != nil {
+		c.log.Printf("[error] Unable to print comparison: %s", err)
+		return 1
+	}
+
+	c.log.Printf("[info] %d resource(s) differ", len(diffs))
+
+	return 0
+}
+
+func (c *Command) defConf(s server) definition.Config {
+	return definition.Config{
+		Key:      s.apiKey,
+		Secret:   s.apiSecret,
+		Scheme:   s.hostScheme,
+		Host:     s.hostAddr,
+		Path:     s.hostPath,
+		ZoneName: s.zoneName,
+		ZoneID:   s.zoneID,
+		Fetchers: c.conf.fetchers,
+	}
+}
+
+func (c *Command) parseFlags(args []string) error {
+	var err error
+
+	if c.conf == nil {
+		return errors.New("command improperly constructed")
+	}
+
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+
+	fs.StringVar(&c.conf.zone.apiKey, "key", "", "API Key")
+	fs.StringVar(&c.conf.zone.apiSecret, "secret", "", "API Secret")
+	fs.StringVar(&c.conf.zone.hostScheme, "scheme", definition.DefaultScheme, "HTTP Scheme to use (http or https)")
+	fs.StringVar(&c.conf.zone.hostAddr, "host", definition.DefaultHost, "CloudStack Management host addr including port")
+	fs.StringVar(&c.conf.zone.hostPath, "path", definition.DefaultPath, "API path")
+	fs.StringVar(&c.conf.zone.zoneID, "zone-id", "", "ID of first Zone (mutually exclusive with zone-name)")
+	fs.StringVar(&c.conf.zone.zoneName, "zone-name", "", "Name of first Zone (mutually exclusive with zone-id)")
+	fs.StringVar(&c.conf.other.apiKey, "other-key", "", "API Key for second Zone")
+	fs.StringVar(&c.conf.other.apiSecret, "other-secret", "", "API Secret for second Zone")
+	fs.StringVar(&c.conf.other.hostScheme, "other-scheme", "", "HTTP Scheme to use for second Zone (http or https)")
+	fs.StringVar(&c.conf.other.hostAddr, "other-host", "", "CloudStack Management host addr including port for second Zone")
+	fs.StringVar(&c.conf.other.hostPath, "other-path", "", "API path for second Zone")
+	fs.StringVar(&c.conf.other.zoneID, "other-zone-id", "", "ID of second Zone (mutually exclusive with other-zone-name)")
+	fs.StringVar(&c.conf.other.zoneName, "other-zone-name", "", "Name of second Zone (mutually exclusive with other-zone-id)")
+	fs.StringVar(&c.conf.fetch, "fetch", strings.Join(definition.CompareFetchers, ","), "Comma-separated list of fetchers to execute")
+	fs.StringVar(&c.conf.format, "format", "text", "Output format (text or json)")
+	fs.StringVar(&c.conf.ignore, "ignore", "", "Comma-separated list of field names to ignore")
+	fs.BoolVar(&c.conf.allFields, "all-fields", false, "Compare volatile fields")
+
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+
+	// the second zone is assumed to share the first's management server unless told otherwise
+	if c.conf.other.apiKey == "" {
+		c.conf.other.apiKey = c.conf.zone.apiKey
+	}
+	if c.conf.other.apiSecret == "" {
+		c.conf.other.apiSecret = c.conf.zone.apiSecret
+	}
+	if c.conf.other.hostScheme == "" {
+		c.conf.other.hostScheme = c.conf.zone.hostScheme
+	}
+	if c.conf.other.hostAddr == "" {
+		c.conf.other.hostAddr = c.conf.zone.hostAddr
+	}
+	if c.conf.other.hostPath == "" {
+		c.conf.other.hostPath = c.conf.zone.hostPath
+	}
+
+	configOK := c.checkServer("", &c.conf.zone)
+	if !c.checkServer("other-", &c.conf.other) {
+		configOK = false
+	}
+
+	c.conf.format = strings.ToLower(c.conf.format)
+	if c.conf.format != "text" && c.conf.format != "json" {
+		c.log.Println("[error] format must be \"text\" or \"json\"")
+		configOK = false
+	}
+	if !c.conf.allFields {
+		c.conf.options.Ignore = append(c.conf.options.Ignore, definition.DefaultVolatileFields...)
+	}
+	if c.conf.ignore != "" {
+		c.conf.options.Ignore = append(c.conf.options.Ignore, strings.Split(c.conf.ignore, ",")...)
+	}
+	for _, pattern := range c.conf.options.Ignore {
+		if _, err = path.Match(pattern, ""); err != nil {
+			c.log.Printf("[error] Invalid ignore pattern \"%s\": %s", pattern, err)
+			configOK = false
+		}
+	}
+
+	c.conf.fetchers = make([]definition.Fetcher, 0)
+	for _, name := range strings.Split(c.conf.fetch, ",") {
+		if fn, ok := definition.GetFetcher(name); !ok {
+			configOK = false
+			c.log.Printf("[error] no fetcher \"%s\" defined", name)
+		} else {
+			c.conf.fetchers = append(c.conf.fetchers, fn)
+		}
+	}
+
+	if !configOK {
+		return errors.New("error parsing flags, see log")
+	}
+
+	c.log.Println("[info] Using parameters:")
+	c.logServer("", c.conf.zone)
+	c.logServer("Other", c.conf.other)
+	c.log.Println("[info]   Format: " + c.conf.format)
+	if c.conf.ignore != "" {
+		c.log.Println("[info]   Ignore: " + c.conf.ignore)
+	}
+	if c.conf.allFields {
+		c.log.Println("[info]   AllFields: true")
+	}
+
+	return nil
+}
+
+func (c *Command) checkServer(prefix string, s *server) bool {
+	ok := true
+	if s.apiKey == "" {
+		c.log.Printf("[error] %skey cannot be empty", prefix)
+		ok = false
+	}
+	if s.apiSecret == "" {
+		c.log.Printf("[error] %ssecret cannot be empty", prefix)
+		ok = false
+	}
+	s.hostScheme = strings.ToLower(s.hostScheme)
+	if s.hostScheme != "http" && s.hostScheme != "https" {
+		c.log.Printf("[error] %sscheme must be \"http\" or \"https\"", prefix)
+		ok = false
+	}
+	if s.hostAddr == "" {
+		c.log.Printf("[error] %shost cannot be empty", prefix)
+		ok = false
+	}
+	if s.hostPath == "" {
+		c.log.Printf("[error] %spath cannot be empty", prefix)
+		ok = false
+	}
+	if s.zoneName == "" && s.zoneID == "" {
+		c.log.Printf("[error] %szone-id or %szone-name must be set", prefix, prefix)
+		ok = false
+	} else if s.zoneName != "" && s.zoneID != "" {
+		c.log.Printf("[error] %szone-id and %szone-name are mutually exclusive", prefix, prefix)
+		ok = false
+	}
+	return ok
+}
+
+func (c *Command) logServer(prefix string, s server) {
+	c.log.Println("[info]   " + prefix + "APIKey: " + s.apiKey)
+	c.log.Println("[info]   " + prefix + "APISecret: " + s.apiSecret)
+	c.log.Println("[info]   " + prefix + "HostScheme: " + s.hostScheme)
+	c.log.Println("[info]   " + prefix + "HostAddr: " + s.hostAddr)
+	c.log.Println("[info]   " + prefix + "HostPath: " + s.hostPath)
+	if s.zoneID != "" {
+		c.log.Println("[info]   " + prefix + "ZoneID: " + s.zoneID)
+	} else {
+		c.log.Println("[info]   " + prefix + "ZoneName: " + s.zoneName)
+	}
+}
@@ -0,0 +1,242 @@
+package diff
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/dcarbone/cs-zone-cloner/command"
+	"github.com/dcarbone/cs-zone-cloner/definition"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+type config struct {
+	from string
+	to   string
+
+	format    string
+	ignore    string
+	allFields bool
+
+	fromZone *definition.ZoneDefinition
+	toZone   *definition.ZoneDefinition
+	options  definition.DiffOptions
+}
+
+type Command struct {
+	self string
+	log  command.Logger
+	conf *config
+}
+
+func New(self string, log command.Logger) *Command {
+	c := &Command{
+		self: self,
+		log:  log,
+		conf: new(config),
+	}
+	return c
+}
+
+func (Command) Synopsis() string {
+	return "Compare two Zone backups"
+}
+
+func (c Command) Help() string {
+	return fmt.Sprintf(`Usage: %s diff [options]
+    Report the resources added, removed and changed between two backups
+
+Required:
+    -from           Earlier backup file
+    -to             Later backup file
+
+Optional:
+    -format         "text", "json", "json-patch" (RFC 6902) or "merge-patch" (RFC 7396) (default: text)
+    -ignore         Comma-separated glob patterns of additional field names to leave out of the comparison
+    -all-fields     Compare the volatile fields that are otherwise ignored (%s)
+
+`,
+		c.self,
+		strings.Join(definition.DefaultVolatileFields, ","))
+}
+
+func (c *Command) Run(args []string) int {
+	var err error
+
+	if err = c.parseFlags(args); err != nil {
+		c.log.Printf("[error] Setup failed: %s", err)
+		return 1
+	}
+
+	switch c.conf.format {
+	case "json-patch", "merge-patch":
+		return c.runPatch()
+	}
+
+	diffs, err := definition.Diff(c.conf.fromZone, c.conf.toZone, c.conf.options)
+	if err != nil {
+		c.log.Printf("[error] Execution failed: %s", err)
+		return 1
+	}
+
+	if err = PrintDiff(diffs, c.conf.format); err != nil {
+		c.log.Printf("[error] Unable to print diff: %s", err)
+		return 1
+	}
+
+	c.log.Printf("[info] %d resource(s) differ", len(diffs))
+
+	return 0
+}
+
+func (c *Command) runPatch() int {
+	var patch interface{}
+	var err error
+
+	if c.conf.format == "json-patch" {
+		patch, err = definition.JSONPatch(c.conf.fromZone, c.conf.toZone, c.conf.options)
+	} else {
+		patch, err = definition.MergePatch(c.conf.fromZone, c.conf.toZone, c.conf.options)
+	}
+	if err != nil {
+		c.log.Printf("[error] Execution failed: %s", err)
+		return 1
+	}
+
+	b, err := json.MarshalIndent(patch, "", "  ")
+	if err != nil {
+		c.log.Printf("[error] Unable to print patch: %s", err)
+		return 1
+	}
+	fmt.Println(string(b))
+
+	return 0
+}
+
+func (c *Command) parseFlags(args []string) error {
+	var err error
+
+	if c.conf == nil {
+		return errors.New("command improperly constructed")
+	}
+
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+
+	fs.StringVar(&c.conf.from, "from", "", "Earlier backup file")
+	fs.StringVar(&c.conf.to, "to", "", "Later backup file")
+	fs.StringVar(&c.conf.format, "format", "text", "Output format (text, json, json-patch or merge-patch)")
+	fs.StringVar(&c.conf.ignore, "ignore", "", "Comma-separated list of field names to ignore")
+	fs.BoolVar(&c.conf.allFields, "all-fields", false, "Compare volatile fields")
+
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+
+	configOK := true
+
+	if c.conf.fromZone, err = readDefinition("from", c.conf.from, c.log); err != nil {
+		configOK = false
+	}
+	if c.conf.toZone, err = readDefinition("to", c.conf.to, c.log); err != nil {
+		configOK = false
+	}
+	c.conf.format = strings.ToLower(c.conf.format)
+	switch c.conf.format {
+	case "text", "json", "json-patch", "merge-patch":
+	default:
+		c.log.Println("[error] format must be \"text\", \"json\", \"json-patch\" or \"merge-patch\"")
+		configOK = false
+	}
+	if !c.conf.allFields {
+		c.conf.options.Ignore = append(c.conf.options.Ignore, definition.DefaultVolatileFields...)
+	}
+	if c.conf.ignore != "" {
+		c.conf.options.Ignore = append(c.conf.options.Ignore, strings.Split(c.conf.ignore, ",")...)
+	}
+	for _, pattern := range c.conf.options.Ignore {
+		if _, err = path.Match(pattern, ""); err != nil {
+			c.log.Printf("[error] Invalid ignore pattern \"%s\": %s", pattern, err)
+			configOK = false
+		}
+	}
+
+	if !configOK {
+		return errors.New("error parsing flags, see log")
+	}
+
+	c.log.Println("[info] Using parameters:")
+	c.log.Println("[info]   From: " + c.conf.from)
+	c.log.Println("[info]   To: " + c.conf.to)
+	c.log.Println("[info]   Format: " + c.conf.format)
+	if c.conf.ignore != "" {
+		c.log.Println("[info]   Ignore: " + c.conf.ignore)
+	}
+	if c.conf.allFields {
+		c.log.Println("[info]   AllFields: true")
+	}
+
+	return nil
+}
+
+func readDefinition(name, filename string, log command.Logger) (*definition.ZoneDefinition, error) {
+	if filename == "" {
+		log.Printf("[error] %s cannot be empty", name)
+		return nil, errors.New("empty filename")
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Printf("[error] Error reading \"%s\": %s", filename, err)
+		return nil, err
+	}
+	zd, _, err := definition.ParseFile(filename, b)
+	if err != nil {
+		log.Printf("[error] Error parsing \"%s\": %s", filename, err)
+		return nil, err
+	}
+	return zd, nil
+}
+
+// PrintDiff writes diffs to stdout as either text or json
+func PrintDiff(diffs []definition.ResourceDiff, format string) error {
+	if format == "json" {
+		b, err := json.MarshalIndent(diffs, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	for _, d := range diffs {
+		switch d.Change {
+		case definition.DiffAdded:
+			fmt.Printf("+ %s %s\n", d.Resource, d.Name)
+		case definition.DiffRemoved:
+			fmt.Printf("- %s %s\n", d.Resource, d.Name)
+		default:
+			fmt.Printf("~ %s %s\n", d.Resource, d.Name)
+			for _, f := range d.Fields {
+				fmt.Printf("      %s: %s => %s\n", f.Field, describeValue(f.Before), describeValue(f.After))
+			}
+		}
+	}
+	return nil
+}
+
+func describeValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "(none)"
+	case string:
+		return fmt.Sprintf("%q", v)
+	case json.Number:
+		return v.String()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
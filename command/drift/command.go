@@ -0,0 +1,250 @@
+package drift
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/dcarbone/cs-zone-cloner/command"
+	"github.com/dcarbone/cs-zone-cloner/command/diff"
+	"github.com/dcarbone/cs-zone-cloner/definition"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// ExitDrift is returned when the live zone differs from the baseline, as opposed to 1 when the check itself fails
+const ExitDrift = 2
+
+type config struct {
+	apiKey    string
+	apiSecret string
+
+	hostScheme string
+	hostAddr   string
+	hostPath   string
+
+	zoneID   string
+	zoneName string
+
+	baseline string
+
+	format    string
+	ignore    string
+	allFields bool
+
+	fetch    string
+	fetchers []definition.Fetcher
+
+	zone    *definition.ZoneDefinition
+	options definition.DiffOptions
+}
+
+type Command struct {
+	self string
+	log  command.Logger
+	conf *config
+}
+
+func New(self string, log command.Logger) *Command {
+	c := &Command{
+		self: self,
+		log:  log,
+		conf: new(config),
+	}
+	return c
+}
+
+func (Command) Synopsis() string {
+	return "Compare a live Zone with a baseline backup"
+}
+
+func (c Command) Help() string {
+	return fmt.Sprintf(`Usage: %s drift [options]
+    Report how a live Zone has drifted from a baseline backup.  Exits with %d when drift is found.
+
+Required:
+    -key            API key
+    -secret         API secret
+    -baseline       Backup file the Zone is expected to match
+
+Optional:
+    -zone-id        ID of Zone to check if different than in the baseline.  Mutually exclusive with "zone-name"
+    -zone-name      Name of Zone to check if different than in the baseline.  Mutually exclusive with "zone-id"
+    -scheme         "http" or "https" (default: %s)
+    -host           Managment Server hostname with port (default: %s)
+    -path           Managment Server api path (default: %s)
+    -fetch          Comma-separated list of fetchers to execute, which should match those the baseline was
+                    taken with (default: %s)
+    -format         "text" or "json" (default: text)
+    -ignore         Comma-separated glob patterns of additional field names to leave out of the comparison
+    -all-fields     Compare the volatile fields that are otherwise ignored (%s)
+
+`,
+		c.self,
+		ExitDrift,
+		definition.DefaultScheme,
+		definition.DefaultHost,
+		definition.DefaultPath,
+		strings.Join(definition.DefaultFetchers(), ","),
+		strings.Join(definition.DefaultVolatileFields, ","))
+}
+
+func (c *Command) Run(args []string) int {
+	var err error
+
+	if err = c.parseFlags(args); err != nil {
+		c.log.Printf("[error] Setup failed: %s", err)
+		return 1
+	}
+
+	defConf := definition.Config{
+		Key:      c.conf.apiKey,
+		Secret:   c.conf.apiSecret,
+		Scheme:   c.conf.hostScheme,
+		Host:     c.conf.hostAddr,
+		Path:     c.conf.hostPath,
+		ZoneName: c.conf.zoneName,
+		ZoneID:   c.conf.zoneID,
+		Fetchers: c.conf.fetchers,
+	}
+
+	definition.SetPackageLogger(c.log)
+
+	diffs, err := definition.Drift(defConf, c.conf.zone, c.conf.options)
+	if err != nil {
+		c.log.Printf("[error] Execution failed: %s", err)
+		return 1
+	}
+
+	if err = diff.PrintDiff(diffs, c.conf.format); err != nil {
+		c.log.Printf("[error] Unable to print drift: %s", err)
+		return 1
+	}
+
+	if len(diffs) > 0 {
+		c.log.Printf("[info] %d resource(s) have drifted from the baseline", len(diffs))
+		return ExitDrift
+	}
+
+	c.log.Println("[info] No drift found")
+
+	return 0
+}
+
+func (c *Command) parseFlags(args []string) error {
+	var err error
+
+	if c.conf == nil {
+		return errors.New("command improperly constructed")
+	}
+
+	fs := flag.NewFlagSet("drift", flag.ContinueOnError)
+
+	fs.StringVar(&c.conf.apiKey, "key", "", "API Key")
+	fs.StringVar(&c.conf.apiSecret, "secret", "", "API Secret")
+	fs.StringVar(&c.conf.hostScheme, "scheme", definition.DefaultScheme, "HTTP Scheme to use (http or https)")
+	fs.StringVar(&c.conf.hostAddr, "host", definition.DefaultHost, "CloudStack Management host addr including port")
+	fs.StringVar(&c.conf.hostPath, "path", definition.DefaultPath, "API path")
+	fs.StringVar(&c.conf.zoneID, "zone-id", "", "ID of Zone to check (mutually exclusive with zone-name)")
+	fs.StringVar(&c.conf.zoneName, "zone-name", "", "Name of Zone to check (mutually exclusive with zone-id)")
+	fs.StringVar(&c.conf.baseline, "baseline", "", "Baseline backup file")
+	fs.StringVar(&c.conf.fetch, "fetch", strings.Join(definition.DefaultFetchers(), ","), "Comma-separated list of fetchers to execute")
+	fs.StringVar(&c.conf.format, "format", "text", "Output format (text or json)")
+	fs.StringVar(&c.conf.ignore, "ignore", "", "Comma-separated list of field names to ignore")
+	fs.BoolVar(&c.conf.allFields, "all-fields", false, "Compare volatile fields")
+
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+
+	configOK := true
+
+	if c.conf.apiKey == "" {
+		c.log.Println("[error] key cannot be empty")
+		configOK = false
+	}
+	if c.conf.apiSecret == "" {
+		c.log.Println("[error] secret cannot be empty")
+		configOK = false
+	}
+	c.conf.hostScheme = strings.ToLower(c.conf.hostScheme)
+	if c.conf.hostScheme != "http" && c.conf.hostScheme != "https" {
+		c.log.Println("[error] scheme must be \"http\" or \"https\"")
+		configOK = false
+	}
+	if c.conf.hostAddr == "" {
+		c.log.Println("[error] host cannot be empty")
+		configOK = false
+	}
+	if c.conf.hostPath == "" {
+		c.log.Println("[error] path cannot be empty")
+		configOK = false
+	}
+	if c.conf.zoneName != "" && c.conf.zoneID != "" {
+		c.log.Println("[error] zone-id and zone-name are mutually exclusive")
+		configOK = false
+	}
+	if c.conf.baseline == "" {
+		c.log.Println("[error] baseline cannot be empty")
+		configOK = false
+	} else if b, err := ioutil.ReadFile(c.conf.baseline); err != nil {
+		c.log.Printf("[error] Error reading \"%s\": %s", c.conf.baseline, err)
+		configOK = false
+	} else if c.conf.zone, _, err = definition.ParseFile(c.conf.baseline, b); err != nil {
+		c.log.Printf("[error] Error parsing \"%s\": %s", c.conf.baseline, err)
+		configOK = false
+	}
+	c.conf.format = strings.ToLower(c.conf.format)
+	if c.conf.format != "text" && c.conf.format != "json" {
+		c.log.Println("[error] format must be \"text\" or \"json\"")
+		configOK = false
+	}
+	if !c.conf.allFields {
+		c.conf.options.Ignore = append(c.conf.options.Ignore, definition.DefaultVolatileFields...)
+	}
+	if c.conf.ignore != "" {
+		c.conf.options.Ignore = append(c.conf.options.Ignore, strings.Split(c.conf.ignore, ",")...)
+	}
+	for _, pattern := range c.conf.options.Ignore {
+		if _, err = path.Match(pattern, ""); err != nil {
+			c.log.Printf("[error] Invalid ignore pattern \"%s\": %s", pattern, err)
+			configOK = false
+		}
+	}
+
+	c.conf.fetchers = make([]definition.Fetcher, 0)
+	for _, name := range strings.Split(c.conf.fetch, ",") {
+		if fn, ok := definition.GetFetcher(name); !ok {
+			configOK = false
+			c.log.Printf("[error] no fetcher \"%s\" defined", name)
+		} else {
+			c.conf.fetchers = append(c.conf.fetchers, fn)
+		}
+	}
+
+	if !configOK {
+		return errors.New("error parsing flags, see log")
+	}
+
+	c.log.Println("[info] Using parameters:")
+	c.log.Println("[info]   APIKey: " + c.conf.apiKey)
+	c.log.Println("[info]   APISecret: " + c.conf.apiSecret)
+	c.log.Println("[info]   HostScheme: " + c.conf.hostScheme)
+	c.log.Println("[info]   HostAddr: " + c.conf.hostAddr)
+	c.log.Println("[info]   HostPath: " + c.conf.hostPath)
+	if c.conf.zoneID != "" {
+		c.log.Println("[info]   ZoneID: " + c.conf.zoneID)
+	} else if c.conf.zoneName != "" {
+		c.log.Println("[info]   ZoneName: " + c.conf.zoneName)
+	}
+	c.log.Println("[info]   Baseline: " + c.conf.baseline)
+	c.log.Println("[info]   Format: " + c.conf.format)
+	if c.conf.ignore != "" {
+		c.log.Println("[info]   Ignore: " + c.conf.ignore)
+	}
+	if c.conf.allFields {
+		c.log.Println("[info]   AllFields: true")
+	}
+
+	return nil
+}
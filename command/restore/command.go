@@ -1,9 +1,17 @@
 package restore
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/dcarbone/cs-zone-cloner/command"
 	"github.com/dcarbone/cs-zone-cloner/definition"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
 )
 
 type config struct {
@@ -14,6 +22,32 @@ type config struct {
 	hostAddr   string
 	hostPath   string
 
+	input string
+
+	clusterParams   string
+	hostCredentials string
+	storageRewrites string
+	storageURLs     string
+	storeSecrets    string
+	networkLabels   string
+	configAllow     string
+	configDeny      string
+	templateURLs    string
+
+	options    definition.RestoreOptions
+	planFormat string
+
+	filter definition.RestoreFilter
+
+	journal  string
+	rollback string
+	idMap    string
+
+	checkpoint string
+	resume     string
+
+	inputs definition.RestoreInputs
+
 	zone     *definition.ZoneDefinition
 	zoneID   string
 	zoneName string
@@ -45,22 +79,554 @@ func (c Command) Help() string {
 Required:
     -key            API key
     -secret         API secret
+    -input          Backup file to restore from, unless -rollback is used.  Its format is detected from its
+                    extension or content
 
 Optional:
     -zone-id        ID of Zone to restore values into if different than in Definition.  Mutually exclusive with "zone-name"
     -zone-name      Name of Zone to restore values into if different than in Definition.  Mutually exclusive with "zone-id"
-    -scheme         "http" or "https" (default: %s) 
+    -scheme         "http" or "https" (default: %s)
     -host           Managment Server hostname with port (default: %s)
     -path           Managment Server api path (default: %s)
-    
+    -cluster-params JSON file with per-hypervisor and per-cluster parameters used when adding clusters
+    -host-creds     JSON file with host credentials keyed by host name or ip, "*" being used as the default
+    -storage-map    JSON file mapping source storage urls, or url prefixes, to the urls to use in the target
+    -storage-urls   JSON file with the urls of primary storage pools keyed by pool name, for pool types whose url
+                    cannot be rebuilt from a backup.  These are used as given, without -storage-map applied.
+    -store-secrets  JSON file with provider details, such as S3 keys, for image and staging stores keyed by name
+    -label-map      JSON file mapping source hypervisor network labels to the labels to use in the target
+    -config-allow   Comma-separated glob patterns of configuration keys to restore (default: all)
+    -config-deny    Comma-separated glob patterns of configuration keys never to restore, in addition to the
+                    keys describing the source environment: %s
+    -no-default-config-deny
+                    Also restore the keys describing the source environment, such as host addresses and secrets
+    -template-urls  JSON file with template download urls keyed by template name or source id
+    -system-templates
+                    Register system and builtin templates along with user templates
+    -template-timeout
+                    How long to wait for registered templates to download (default: %s)
+    -plan           List the api calls the restore would make without making any of them
+    -plan-format    "text" or "json" (default: text)
+    -journal        File to record each created resource in, along with the call that deletes it
+    -rollback-on-failure
+                    Delete every resource created by this run if a step fails
+    -rollback       Delete the resources recorded in a journal file, newest first, instead of restoring
+    -id-map         File to write the mapping of source resource ids to target resource ids to, as JSON
+    -only           Comma-separated selectors of what to restore, each a step name optionally followed by
+                    "=" and a name pattern, e.g. "computeOfferings,pods=POD-A*".  May be repeated.
+    -skip           Comma-separated selectors of what not to restore, in the same form as -only
+    -no-parents     Do not also restore the parents of resources selected by -only, such as the pod of a cluster
+    -no-children    Do not also restore what belongs to pods and clusters selected by -only.  By default
+                    selecting a pod also selects its clusters, and selecting a pod or cluster also selects the
+                    hosts and cluster scoped primary storage pools of its clusters.
+    -checkpoint     File to record the progress of the restore in after each step
+    -resume         Checkpoint file of an interrupted restore to continue from.  Progress continues to be
+                    recorded in it unless -checkpoint names another file.
+
+Steps: %s
+
 `,
 		c.self,
 		definition.DefaultScheme,
 		definition.DefaultHost,
-		definition.DefaultPath)
+		definition.DefaultPath,
+		strings.Join(definition.DefaultConfigurationDeny, ","),
+		definition.DefaultTemplateTimeout,
+		strings.Join(definition.DefaultRestorers(), ", "))
 }
 
 func (c *Command) Run(args []string) int {
+	var err error
+
+	if err = c.parseFlags(args); err != nil {
+		c.log.Printf("[error] Setup failed: %s", err)
+		return 1
+	}
+
+	restConf := definition.RestoreConfig{
+		Key:      c.conf.apiKey,
+		Secret:   c.conf.apiSecret,
+		Scheme:   c.conf.hostScheme,
+		Host:     c.conf.hostAddr,
+		Path:     c.conf.hostPath,
+		ZoneID:   c.conf.zoneID,
+		ZoneName: c.conf.zoneName,
+		Inputs:   c.conf.inputs,
+		Options:  c.conf.options,
+		Filter:   c.conf.filter,
+	}
+
+	definition.SetPackageLogger(c.log)
+
+	if c.conf.rollback != "" {
+		return c.runRollback(restConf)
+	}
+
+	// entries from earlier runs are kept, so one journal may cover a restore that took several attempts
+	var previous []definition.JournalEntry
+	if c.conf.journal != "" && !c.conf.options.DryRun {
+		if b, err := ioutil.ReadFile(c.conf.journal); err == nil {
+			if previous, err = definition.ParseJournal(b); err != nil {
+				c.log.Printf("[error] Unable to read journal \"%s\": %s", c.conf.journal, err)
+				return 1
+			}
+		}
+		f, err := os.OpenFile(c.conf.journal, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			c.log.Printf("[error] Unable to open journal \"%s\": %s", c.conf.journal, err)
+			return 1
+		}
+		defer f.Close()
+		restConf.Journal = f
+	}
+
+	if c.conf.resume != "" {
+		b, err := ioutil.ReadFile(c.conf.resume)
+		if err != nil {
+			c.log.Printf("[error] Unable to read checkpoint \"%s\": %s", c.conf.resume, err)
+			return 1
+		}
+		if restConf.Resume, err = definition.ParseRestoreState(b); err != nil {
+			c.log.Printf("[error] Unable to parse checkpoint \"%s\": %s", c.conf.resume, err)
+			return 1
+		}
+	}
+	if c.conf.checkpoint != "" {
+		restConf.Checkpoint = func(state *definition.RestoreState) error {
+			return writeCheckpointFile(c.conf.checkpoint, state)
+		}
+	}
+
+	rs, err := definition.RestoreDefinition(restConf, c.conf.zone)
+	if rs != nil && restConf.Journal != nil && c.conf.options.RollbackOnFailure && err != nil {
+		// whatever was rolled back no longer belongs in the journal
+		if jerr := writeJournalFile(c.conf.journal, append(previous, rs.Journal...)); jerr != nil {
+			c.log.Printf("[error] Unable to update journal \"%s\": %s", c.conf.journal, jerr)
+		}
+	}
+	if rs != nil && c.conf.idMap != "" && !c.conf.options.DryRun {
+		// written even when the restore failed, covering whatever had been restored by then
+		if merr := writeIDMapFile(c.conf.idMap, rs.IDMap()); merr != nil {
+			c.log.Printf("[error] Unable to write id map \"%s\": %s", c.conf.idMap, merr)
+		}
+	}
+	if rs != nil && c.conf.options.DryRun {
+		if perr := c.printPlan(rs); perr != nil {
+			c.log.Printf("[error] Unable to print plan: %s", perr)
+			return 1
+		}
+	} else if rs != nil {
+		c.log.Println("[info] Restore results:")
+		for _, res := range rs.Results {
+			if res.Message == "" {
+				c.log.Printf("[info]   %s %s: %s", res.Resource, res.Name, res.Status)
+			} else {
+				c.log.Printf("[info]   %s %s: %s (%s)", res.Resource, res.Name, res.Status, res.Message)
+			}
+		}
+	}
+	if err != nil {
+		c.log.Printf("[error] Execution failed: %s", err)
+		return 1
+	}
+
+	if c.conf.options.DryRun {
+		c.log.Println("[info] Plan complete, no changes were made")
+	} else {
+		c.log.Println("[info] Definition restored")
+	}
+
+	return 0
+}
+
+func (c *Command) runRollback(restConf definition.RestoreConfig) int {
+	b, err := ioutil.ReadFile(c.conf.rollback)
+	if err != nil {
+		c.log.Printf("[error] Unable to read journal \"%s\": %s", c.conf.rollback, err)
+		return 1
+	}
+	entries, err := definition.ParseJournal(b)
+	if err != nil {
+		c.log.Printf("[error] Unable to parse journal \"%s\": %s", c.conf.rollback, err)
+		return 1
+	}
+
+	rs, err := definition.RollbackJournal(restConf, entries)
+	if rs != nil && c.conf.options.DryRun {
+		if perr := c.printPlan(rs); perr != nil {
+			c.log.Printf("[error] Unable to print plan: %s", perr)
+			return 1
+		}
+	} else if rs != nil {
+		c.log.Println("[info] Rollback results:")
+		for _, res := range rs.Results {
+			c.log.Printf("[info]   %s %s: %s", res.Resource, res.Name, res.Status)
+		}
+		// the journal is left holding only what could not be deleted, so a rollback may simply be retried
+		if jerr := writeJournalFile(c.conf.rollback, rs.Journal); jerr != nil {
+			c.log.Printf("[error] Unable to update journal \"%s\": %s", c.conf.rollback, jerr)
+		}
+	}
+	if err != nil {
+		c.log.Printf("[error] Rollback failed: %s", err)
+		return 1
+	}
+
+	if c.conf.options.DryRun {
+		c.log.Println("[info] Plan complete, no changes were made")
+	} else {
+		c.log.Println("[info] Rollback complete")
+	}
 
 	return 0
 }
+
+// printPlan writes the api calls a dry run would have made to stdout
+func (c *Command) printPlan(rs *definition.Restoration) error {
+	if c.conf.planFormat == "json" {
+		b, err := json.MarshalIndent(rs.Results, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	for i, res := range rs.Results {
+		fmt.Printf("%3d. %-6s %s %s", i+1, res.PlanAction(), res.Resource, res.Name)
+		if res.Command != "" {
+			fmt.Printf(" [%s]", res.Command)
+		}
+		if res.Message != "" {
+			fmt.Printf(" (%s)", res.Message)
+		}
+		fmt.Println()
+		keys := make([]string, 0, len(res.Params))
+		for k := range res.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("          %s=%s\n", k, res.Params[k])
+		}
+	}
+	return nil
+}
+
+func (c *Command) parseFlags(args []string) error {
+	var err error
+
+	if c.conf == nil {
+		return errors.New("command improperly constructed")
+	}
+
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+
+	fs.StringVar(&c.conf.apiKey, "key", "", "API Key")
+	fs.StringVar(&c.conf.apiSecret, "secret", "", "API Secret")
+	fs.StringVar(&c.conf.hostScheme, "scheme", definition.DefaultScheme, "HTTP Scheme to use (http or https)")
+	fs.StringVar(&c.conf.hostAddr, "host", definition.DefaultHost, "CloudStack Management host addr including port")
+	fs.StringVar(&c.conf.hostPath, "path", definition.DefaultPath, "API path")
+	fs.StringVar(&c.conf.zoneID, "zone-id", "", "ID of Zone to restore into (mutually exclusive with zone-name)")
+	fs.StringVar(&c.conf.zoneName, "zone-name", "", "Name of Zone to restore into (mutually exclusive with zone-id)")
+	fs.StringVar(&c.conf.input, "input", "", "Backup file to restore from")
+	fs.StringVar(&c.conf.clusterParams, "cluster-params", "", "Cluster parameters file")
+	fs.StringVar(&c.conf.hostCredentials, "host-creds", "", "Host credentials file")
+	fs.StringVar(&c.conf.storageRewrites, "storage-map", "", "Storage url rewrite file")
+	fs.StringVar(&c.conf.storageURLs, "storage-urls", "", "Primary storage pool url file")
+	fs.StringVar(&c.conf.storeSecrets, "store-secrets", "", "Image store secrets file")
+	fs.StringVar(&c.conf.networkLabels, "label-map", "", "Network label remap file")
+	fs.StringVar(&c.conf.configAllow, "config-allow", "", "Comma-separated list of configuration keys to restore")
+	fs.StringVar(&c.conf.configDeny, "config-deny", "", "Comma-separated list of configuration keys never to restore")
+	fs.BoolVar(&c.conf.inputs.Configuration.NoDefaultDeny, "no-default-config-deny", false, "Restore configuration keys describing the source environment")
+	fs.StringVar(&c.conf.templateURLs, "template-urls", "", "Template url file")
+	fs.BoolVar(&c.conf.options.IncludeSystemTemplates, "system-templates", false, "Register system templates")
+	fs.DurationVar(&c.conf.options.TemplateTimeout, "template-timeout", definition.DefaultTemplateTimeout, "Template download timeout")
+	fs.BoolVar(&c.conf.options.DryRun, "plan", false, "List api calls without making them")
+	fs.StringVar(&c.conf.planFormat, "plan-format", "text", "Plan output format (text or json)")
+	fs.StringVar(&c.conf.journal, "journal", "", "Journal file to record created resources in")
+	fs.BoolVar(&c.conf.options.RollbackOnFailure, "rollback-on-failure", false, "Delete created resources if the restore fails")
+	fs.StringVar(&c.conf.rollback, "rollback", "", "Journal file to roll back")
+	fs.StringVar(&c.conf.idMap, "id-map", "", "File to write source to target id mappings to")
+	fs.Var(selectorFlag(c.conf.filter.AddOnly), "only", "Resources to restore")
+	fs.Var(selectorFlag(c.conf.filter.AddSkip), "skip", "Resources not to restore")
+	fs.BoolVar(&c.conf.filter.NoParents, "no-parents", false, "Do not restore the parents of selected resources")
+	fs.BoolVar(&c.conf.filter.NoChildren, "no-children", false, "Do not restore the children of selected pods and clusters")
+	fs.StringVar(&c.conf.checkpoint, "checkpoint", "", "File to record restore progress in")
+	fs.StringVar(&c.conf.resume, "resume", "", "Checkpoint file to resume a restore from")
+
+	if err = fs.Parse(args); err != nil {
+		return err
+	}
+
+	configOK := true
+
+	if c.conf.apiKey == "" {
+		c.log.Println("[error] key cannot be empty")
+		configOK = false
+	}
+	if c.conf.apiSecret == "" {
+		c.log.Println("[error] secret cannot be empty")
+		configOK = false
+	}
+	c.conf.hostScheme = strings.ToLower(c.conf.hostScheme)
+	if c.conf.hostScheme != "http" && c.conf.hostScheme != "https" {
+		c.log.Println("[error] scheme must be \"http\" or \"https\"")
+		configOK = false
+	}
+	if c.conf.hostAddr == "" {
+		c.log.Println("[error] host cannot be empty")
+		configOK = false
+	}
+	if c.conf.hostPath == "" {
+		c.log.Println("[error] path cannot be empty")
+		configOK = false
+	}
+	if c.conf.zoneName != "" && c.conf.zoneID != "" {
+		c.log.Println("[error] zone-id and zone-name are mutually exclusive")
+		configOK = false
+	}
+	c.conf.planFormat = strings.ToLower(c.conf.planFormat)
+	if c.conf.planFormat != "text" && c.conf.planFormat != "json" {
+		c.log.Println("[error] plan-format must be \"text\" or \"json\"")
+		configOK = false
+	}
+	if c.conf.rollback != "" {
+		if c.conf.input != "" || c.conf.journal != "" || c.conf.idMap != "" || c.conf.checkpoint != "" || c.conf.resume != "" {
+			c.log.Println("[error] rollback cannot be combined with input, journal, id-map, checkpoint or resume")
+			configOK = false
+		}
+	} else if c.conf.input == "" {
+		c.log.Println("[error] input cannot be empty")
+		configOK = false
+	} else if b, err := ioutil.ReadFile(c.conf.input); err != nil {
+		c.log.Printf("[error] Error reading \"%s\": %s", c.conf.input, err)
+		configOK = false
+	} else if c.conf.zone, _, err = definition.ParseFile(c.conf.input, b); err != nil {
+		c.log.Printf("[error] Error parsing \"%s\": %s", c.conf.input, err)
+		configOK = false
+	}
+
+	if c.conf.clusterParams != "" {
+		if err = readJSONFile(c.conf.clusterParams, &c.conf.inputs.ClusterParameters); err != nil {
+			c.log.Printf("[error] Error reading cluster parameters: %s", err)
+			configOK = false
+		}
+	}
+
+	if c.conf.hostCredentials != "" {
+		if err = readJSONFile(c.conf.hostCredentials, &c.conf.inputs.HostCredentials); err != nil {
+			c.log.Printf("[error] Error reading host credentials: %s", err)
+			configOK = false
+		}
+	}
+
+	if c.conf.storageRewrites != "" {
+		if err = readJSONFile(c.conf.storageRewrites, &c.conf.inputs.StorageRewrites); err != nil {
+			c.log.Printf("[error] Error reading storage map: %s", err)
+			configOK = false
+		}
+	}
+
+	if c.conf.storageURLs != "" {
+		if err = readJSONFile(c.conf.storageURLs, &c.conf.inputs.StorageURLs); err != nil {
+			c.log.Printf("[error] Error reading storage urls: %s", err)
+			configOK = false
+		}
+	}
+
+	if c.conf.storeSecrets != "" {
+		if err = readJSONFile(c.conf.storeSecrets, &c.conf.inputs.ImageStoreSecrets); err != nil {
+			c.log.Printf("[error] Error reading store secrets: %s", err)
+			configOK = false
+		}
+	}
+
+	if c.conf.networkLabels != "" {
+		if err = readJSONFile(c.conf.networkLabels, &c.conf.inputs.NetworkLabels); err != nil {
+			c.log.Printf("[error] Error reading label map: %s", err)
+			configOK = false
+		}
+	}
+
+	if c.conf.templateURLs != "" {
+		if err = readJSONFile(c.conf.templateURLs, &c.conf.inputs.TemplateURLs); err != nil {
+			c.log.Printf("[error] Error reading template urls: %s", err)
+			configOK = false
+		}
+	}
+	if c.conf.configAllow != "" {
+		c.conf.inputs.Configuration.Allow = strings.Split(c.conf.configAllow, ",")
+	}
+	if c.conf.configDeny != "" {
+		c.conf.inputs.Configuration.Deny = strings.Split(c.conf.configDeny, ",")
+	}
+	for _, pattern := range append(c.conf.inputs.Configuration.Allow, c.conf.inputs.Configuration.Deny...) {
+		if _, err = path.Match(pattern, ""); err != nil {
+			c.log.Printf("[error] Invalid configuration pattern \"%s\": %s", pattern, err)
+			configOK = false
+		}
+	}
+
+	if c.conf.resume != "" && c.conf.checkpoint == "" {
+		c.conf.checkpoint = c.conf.resume
+	}
+
+	if !configOK {
+		return errors.New("error parsing flags, see log")
+	}
+
+	c.log.Println("[info] Using parameters:")
+	c.log.Println("[info]   APIKey: " + c.conf.apiKey)
+	c.log.Println("[info]   APISecret: " + c.conf.apiSecret)
+	c.log.Println("[info]   HostScheme: " + c.conf.hostScheme)
+	c.log.Println("[info]   HostAddr: " + c.conf.hostAddr)
+	c.log.Println("[info]   HostPath: " + c.conf.hostPath)
+	if c.conf.rollback != "" {
+		c.log.Println("[info]   Rollback: " + c.conf.rollback)
+	} else {
+		c.log.Println("[info]   Input: " + c.conf.input)
+	}
+	if c.conf.journal != "" {
+		c.log.Println("[info]   Journal: " + c.conf.journal)
+	}
+	if c.conf.options.RollbackOnFailure {
+		c.log.Println("[info]   RollbackOnFailure: true")
+	}
+	if c.conf.idMap != "" {
+		c.log.Println("[info]   IDMap: " + c.conf.idMap)
+	}
+	if len(c.conf.filter.Only) > 0 {
+		c.log.Println("[info]   Only: " + describeSelectors(c.conf.filter.Only))
+	}
+	if len(c.conf.filter.Skip) > 0 {
+		c.log.Println("[info]   Skip: " + describeSelectors(c.conf.filter.Skip))
+	}
+	if c.conf.filter.NoParents {
+		c.log.Println("[info]   NoParents: true")
+	}
+	if c.conf.filter.NoChildren {
+		c.log.Println("[info]   NoChildren: true")
+	}
+	if c.conf.resume != "" {
+		c.log.Println("[info]   Resume: " + c.conf.resume)
+	}
+	if c.conf.checkpoint != "" {
+		c.log.Println("[info]   Checkpoint: " + c.conf.checkpoint)
+	}
+	if c.conf.zoneID != "" {
+		c.log.Println("[info]   ZoneID: " + c.conf.zoneID)
+	} else if c.conf.zoneName != "" {
+		c.log.Println("[info]   ZoneName: " + c.conf.zoneName)
+	}
+	if c.conf.clusterParams != "" {
+		c.log.Println("[info]   ClusterParams: " + c.conf.clusterParams)
+	}
+	if c.conf.hostCredentials != "" {
+		c.log.Println("[info]   HostCredentials: " + c.conf.hostCredentials)
+	}
+	if c.conf.storageRewrites != "" {
+		c.log.Println("[info]   StorageMap: " + c.conf.storageRewrites)
+	}
+	if c.conf.storageURLs != "" {
+		c.log.Println("[info]   StorageURLs: " + c.conf.storageURLs)
+	}
+	if c.conf.storeSecrets != "" {
+		c.log.Println("[info]   StoreSecrets: " + c.conf.storeSecrets)
+	}
+	if c.conf.networkLabels != "" {
+		c.log.Println("[info]   LabelMap: " + c.conf.networkLabels)
+	}
+	if c.conf.configAllow != "" {
+		c.log.Println("[info]   ConfigAllow: " + c.conf.configAllow)
+	}
+	if c.conf.configDeny != "" {
+		c.log.Println("[info]   ConfigDeny: " + c.conf.configDeny)
+	}
+	if c.conf.inputs.Configuration.NoDefaultDeny {
+		c.log.Println("[info]   NoDefaultConfigDeny: true")
+	}
+	if c.conf.templateURLs != "" {
+		c.log.Println("[info]   TemplateURLs: " + c.conf.templateURLs)
+	}
+	if c.conf.options.IncludeSystemTemplates {
+		c.log.Println("[info]   SystemTemplates: true")
+	}
+	c.log.Println("[info]   TemplateTimeout: " + c.conf.options.TemplateTimeout.String())
+	if c.conf.options.DryRun {
+		c.log.Println("[info]   Plan: " + c.conf.planFormat)
+	}
+
+	return nil
+}
+
+func writeJournalFile(filename string, entries []definition.JournalEntry) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return definition.WriteJournal(f, entries)
+}
+
+// selectorFlag adds each selector of a comma-separated list to a filter
+type selectorFlag func(string) error
+
+func (selectorFlag) String() string {
+	return ""
+}
+
+func (sf selectorFlag) Set(v string) error {
+	for _, selector := range strings.Split(v, ",") {
+		if err := sf(strings.TrimSpace(selector)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func describeSelectors(selectors map[string][]string) string {
+	steps := make([]string, 0, len(selectors))
+	for step, patterns := range selectors {
+		if len(patterns) == 0 {
+			steps = append(steps, step)
+			continue
+		}
+		for _, pattern := range patterns {
+			steps = append(steps, step+"="+pattern)
+		}
+	}
+	sort.Strings(steps)
+	return strings.Join(steps, ",")
+}
+
+// writeCheckpointFile replaces the checkpoint in one step, so an interruption mid write cannot lose the last one
+func writeCheckpointFile(filename string, state *definition.RestoreState) error {
+	b, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filename+".tmp", b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+func writeIDMapFile(filename string, m definition.IDMap) error {
+	b, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, b, 0644)
+}
+
+func readJSONFile(filename string, v interface{}) error {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unable to parse \"%s\": %s", filename, err)
+	}
+	return nil
+}
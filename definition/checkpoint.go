@@ -0,0 +1,98 @@
+package definition
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xanzy/go-cloudstack/cloudstack"
+)
+
+// RestoreState is a checkpoint of a restore, taken each time a step completes.  It carries everything later steps
+// need from earlier ones, so that a restore may be resumed without repeating them.
+type RestoreState struct {
+	// SourceZoneID identifies the definition being restored, so a checkpoint is not resumed against another
+	SourceZoneID string `json:"sourceZoneID"`
+	// Completed lists the steps that have finished, in the order they finished
+	Completed []string `json:"completed"`
+	// Target is the target zone as it stood when the checkpoint was taken
+	Target  *ZoneDefinition `json:"target"`
+	Results []RestoreResult `json:"results"`
+	// Domains and OsTypes hold the target ids of the domains and os types looked up by name so far
+	Domains map[string]string `json:"domains"`
+	OsTypes map[string]string `json:"osTypes"`
+}
+
+// ParseRestoreState reads a checkpoint written out as JSON
+func ParseRestoreState(b []byte) (*RestoreState, error) {
+	if len(b) == 0 {
+		return nil, errors.New("input cannot be empty")
+	}
+	state := &RestoreState{Target: NewZoneDefinition(cloudstack.Zone{})}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// State returns a checkpoint of the restore so far
+func (rs *Restoration) State() *RestoreState {
+	return &RestoreState{
+		SourceZoneID: rs.Source.Zone.Id,
+		Completed:    rs.Completed,
+		Target:       rs.Target,
+		Results:      rs.Results,
+		Domains:      rs.domains,
+		OsTypes:      rs.osTypes,
+	}
+}
+
+// resume picks up from a checkpoint taken by an earlier restore of the same definition
+func (rs *Restoration) resume(state *RestoreState) error {
+	if state.SourceZoneID != rs.Source.Zone.Id {
+		return fmt.Errorf("checkpoint is of a restore of zone %s, not %s", state.SourceZoneID, rs.Source.Zone.Id)
+	}
+	if state.Target != nil {
+		rs.Target = state.Target
+		// a zone the earlier run had already settled on is used regardless of how the zone was chosen
+		if rs.Target.Zone.Id != "" {
+			rs.ZoneID = rs.Target.Zone.Id
+			rs.ZoneName = ""
+		} else if rs.ZoneID == "" && rs.ZoneName == "" && rs.Target.Zone.Name != "" {
+			// a zone the earlier run rolled back is created again under the name it was given
+			rs.ZoneName = rs.Target.Zone.Name
+		}
+	}
+	rs.Completed = append(rs.Completed, state.Completed...)
+	rs.Results = append(rs.Results, state.Results...)
+	for name, id := range state.Domains {
+		rs.domains[name] = id
+	}
+	for name, id := range state.OsTypes {
+		rs.osTypes[name] = id
+	}
+	return nil
+}
+
+// checkResumedZone ensures the zone a checkpoint settled on still exists, as resuming would otherwise carry on
+// against resources that are gone
+func (rs *Restoration) checkResumedZone(client *cloudstack.CloudStackClient) error {
+	if rs.Target.Zone.Id == "" || isPlaceholderID(rs.Target.Zone.Id) {
+		return nil
+	}
+	_, count, err := client.Zone.GetZoneByID(rs.Target.Zone.Id)
+	if count == 0 {
+		return fmt.Errorf("zone %s of the checkpoint no longer exists in the target, remove the checkpoint to start over", rs.Target.Zone.Id)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (rs *Restoration) completed(step string) bool {
+	for _, name := range rs.Completed {
+		if name == step {
+			return true
+		}
+	}
+	return false
+}
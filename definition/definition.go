@@ -16,8 +16,18 @@ const (
 )
 
 type (
+	// TrafficTypeLabels holds the per hypervisor network labels of a traffic type, which the client's
+	// TrafficType does not expose
+	TrafficTypeLabels struct {
+		HyperV    string `json:"hypervnetworklabel,omitempty"`
+		KVM       string `json:"kvmnetworklabel,omitempty"`
+		OVM3      string `json:"ovm3networklabel,omitempty"`
+		VMware    string `json:"vmwarenetworklabel,omitempty"`
+		XenServer string `json:"xennetworklabel,omitempty"`
+	}
 	TrafficType struct {
 		cloudstack.TrafficType
+		Labels   TrafficTypeLabels
 		Networks map[string]cloudstack.Network
 	}
 	PhysicalNetwork struct {
@@ -34,18 +44,19 @@ type (
 	}
 
 	ZoneDefinition struct {
-		Zone                  cloudstack.Zone
-		Pods                  map[string]cloudstack.Pod
-		Clusters              map[string]cloudstack.Cluster
-		Hosts                 map[string]cloudstack.Host
-		PrimaryStoragePools   map[string]cloudstack.StoragePool
-		SecondaryStoragePools map[string]cloudstack.ImageStore
-		PhysicalNetworks      map[string]PhysicalNetwork
-		ComputeOfferings      map[string]cloudstack.ServiceOffering
-		DiskOfferings         map[string]cloudstack.DiskOffering
-		Templates             map[string]cloudstack.Template
-		GlobalConfiguration   map[string]cloudstack.Configuration
-		ZoneConfiguration     map[string]cloudstack.Configuration
+		Zone                   cloudstack.Zone
+		Pods                   map[string]cloudstack.Pod
+		Clusters               map[string]cloudstack.Cluster
+		Hosts                  map[string]cloudstack.Host
+		PrimaryStoragePools    map[string]cloudstack.StoragePool
+		SecondaryStoragePools  map[string]cloudstack.ImageStore
+		SecondaryStagingStores map[string]cloudstack.SecondaryStagingStore
+		PhysicalNetworks       map[string]PhysicalNetwork
+		ComputeOfferings       map[string]cloudstack.ServiceOffering
+		DiskOfferings          map[string]cloudstack.DiskOffering
+		Templates              map[string]cloudstack.Template
+		GlobalConfiguration    map[string]cloudstack.Configuration
+		ZoneConfiguration      map[string]cloudstack.Configuration
 
 		Database DatabaseConfig
 
@@ -57,18 +68,19 @@ type (
 
 func NewZoneDefinition(zone cloudstack.Zone) *ZoneDefinition {
 	zd := &ZoneDefinition{
-		Zone:                  zone,
-		Pods:                  make(map[string]cloudstack.Pod),
-		Clusters:              make(map[string]cloudstack.Cluster),
-		Hosts:                 make(map[string]cloudstack.Host),
-		PrimaryStoragePools:   make(map[string]cloudstack.StoragePool),
-		SecondaryStoragePools: make(map[string]cloudstack.ImageStore),
-		PhysicalNetworks:      make(map[string]PhysicalNetwork),
-		ComputeOfferings:      make(map[string]cloudstack.ServiceOffering),
-		DiskOfferings:         make(map[string]cloudstack.DiskOffering),
-		Templates:             make(map[string]cloudstack.Template),
-		ZoneConfiguration:     make(map[string]cloudstack.Configuration),
-		GlobalConfiguration:   make(map[string]cloudstack.Configuration),
+		Zone:                   zone,
+		Pods:                   make(map[string]cloudstack.Pod),
+		Clusters:               make(map[string]cloudstack.Cluster),
+		Hosts:                  make(map[string]cloudstack.Host),
+		PrimaryStoragePools:    make(map[string]cloudstack.StoragePool),
+		SecondaryStoragePools:  make(map[string]cloudstack.ImageStore),
+		SecondaryStagingStores: make(map[string]cloudstack.SecondaryStagingStore),
+		PhysicalNetworks:       make(map[string]PhysicalNetwork),
+		ComputeOfferings:       make(map[string]cloudstack.ServiceOffering),
+		DiskOfferings:          make(map[string]cloudstack.DiskOffering),
+		Templates:              make(map[string]cloudstack.Template),
+		ZoneConfiguration:      make(map[string]cloudstack.Configuration),
+		GlobalConfiguration:    make(map[string]cloudstack.Configuration),
 
 		Custom: make(map[string]interface{}),
 	}
@@ -91,25 +103,14 @@ type (
 	}
 )
 
-func FetchDefinition(conf Config, dbConfig *DatabaseConfig) (*ZoneDefinition, error) {
-	var zone *cloudstack.Zone
-	var count int
-	var err error
-
-	key := conf.Key
-	secret := conf.Secret
-	scheme := conf.Scheme
-	host := conf.Host
-	path := conf.Path
-	zoneName := conf.ZoneName
-	zoneID := conf.ZoneID
+func newClient(key, secret, scheme, host, path string) (*cloudstack.CloudStackClient, error) {
 	if key == "" {
 		return nil, errors.New("key cannot be empty")
 	}
 	if secret == "" {
 		return nil, errors.New("secret cannot be empty")
 	}
-	if scheme != "" && conf.Scheme != "http" && conf.Scheme != "https" {
+	if scheme != "" && scheme != "http" && scheme != "https" {
 		return nil, errors.New("scheme must be http or https")
 	} else if scheme == "" {
 		scheme = DefaultScheme
@@ -120,11 +121,24 @@ func FetchDefinition(conf Config, dbConfig *DatabaseConfig) (*ZoneDefinition, er
 	if path == "" {
 		path = DefaultPath
 	}
+	return cloudstack.NewAsyncClient(fmt.Sprintf("%s://%s%s", scheme, host, path), key, secret, false), nil
+}
+
+func FetchDefinition(conf Config, dbConfig *DatabaseConfig) (*ZoneDefinition, error) {
+	var zone *cloudstack.Zone
+	var count int
+	var err error
+
+	zoneName := conf.ZoneName
+	zoneID := conf.ZoneID
 	if zoneName == "" && zoneID == "" {
 		return nil, errors.New("zone name or id must be populated")
 	}
 
-	client := cloudstack.NewAsyncClient(fmt.Sprintf("%s://%s%s", scheme, host, path), key, secret, false)
+	client, err := newClient(conf.Key, conf.Secret, conf.Scheme, conf.Host, conf.Path)
+	if err != nil {
+		return nil, err
+	}
 	if zoneID == "" {
 		log.Println("Attempting to fetch zone " + zoneName)
 		zone, count, err = client.Zone.GetZoneByName(zoneName)
@@ -132,7 +146,7 @@ func FetchDefinition(conf Config, dbConfig *DatabaseConfig) (*ZoneDefinition, er
 			return nil, err
 		}
 		if count == 0 {
-			return nil, fmt.Errorf("zone " + zoneName + " not found")
+			return nil, fmt.Errorf("zone %s not found", zoneName)
 		}
 	} else {
 		log.Println("Attempting to fetch zone " + zoneID)
@@ -141,7 +155,7 @@ func FetchDefinition(conf Config, dbConfig *DatabaseConfig) (*ZoneDefinition, er
 			return nil, err
 		}
 		if count == 0 {
-			return nil, fmt.Errorf("zone " + zoneID + " not found")
+			return nil, fmt.Errorf("zone %s not found", zoneID)
 		}
 	}
 
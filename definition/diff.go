@@ -0,0 +1,376 @@
+package definition
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// DefaultVolatileFields lists the fields which change in the normal running of a zone, such as capacity, usage
+// and health, and which are therefore left out of a diff by default
+var DefaultVolatileFields = []string{
+	"averageload",
+	"capacity",
+	"cpuallocated",
+	"cpuused",
+	"cpuwithoverprovisioning",
+	"disconnected",
+	"disksizeallocated",
+	"disksizeused",
+	"events",
+	"hasenoughcapacity",
+	"isready",
+	"lastpinged",
+	"managementserverid",
+	"memoryallocated",
+	"memoryused",
+	"networkkbsread",
+	"networkkbswrite",
+	"state",
+	"status",
+	"suitableformigration",
+}
+
+// ZoneSpecificFields lists the fields which necessarily differ between two zones configured alike, such as ids,
+// the zone name and ip ranges.  Compare leaves these out of the comparison.
+var ZoneSpecificFields = []string{
+	"id",
+	"*id",
+	"name",
+	"zonename",
+	"zonetoken",
+	"guestcidraddress",
+	"startip",
+	"endip",
+	"gateway",
+	"netmask",
+	"ipaddress",
+}
+
+// CompareFetchers names the fetchers compare runs by default, those whose resources are named alike in zones
+// configured alike
+var CompareFetchers = []string{
+	"physicalNetworks",
+	"computeOfferings",
+	"diskOfferings",
+	"zoneConfigs",
+	"globalConfigs",
+}
+
+// childResources names the fields of a resource which hold name-keyed resources of their own.  These are diffed
+// as resources in their own right, named "<parent>/<child>".
+var childResources = map[string]string{
+	"PhysicalNetworks": "TrafficTypes",
+}
+
+type (
+	DiffOptions struct {
+		// Ignore holds glob patterns of field names, matched at any depth, to leave out of the comparison
+		Ignore []string
+	}
+
+	// FieldDiff is a single field of a resource that differs.  Before or After is nil when the field is absent.
+	FieldDiff struct {
+		Field  string      `json:"field"`
+		Before interface{} `json:"before"`
+		After  interface{} `json:"after"`
+	}
+
+	// ResourceDiff describes a resource that was added, removed or changed between two definitions.  Resource
+	// is the ZoneDefinition field the resource is found in, e.g. "Pods".
+	ResourceDiff struct {
+		Resource string      `json:"resource"`
+		Name     string      `json:"name"`
+		Change   string      `json:"change"`
+		Fields   []FieldDiff `json:"fields,omitempty"`
+	}
+)
+
+// Diff compares two definitions resource by resource, matching resources on their names
+func Diff(from, to *ZoneDefinition, opts DiffOptions) ([]ResourceDiff, error) {
+	if from == nil || to == nil {
+		return nil, errors.New("zone definitions cannot be empty")
+	}
+	// the zones are paired up whatever their names, a rename showing as a changed field
+	fromRes, err := diffResources(from, to.Zone.Name)
+	if err != nil {
+		return nil, err
+	}
+	toRes, err := diffResources(to, to.Zone.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[diffKey]bool, len(fromRes)+len(toRes))
+	for k := range fromRes {
+		keys[k] = true
+	}
+	for k := range toRes {
+		keys[k] = true
+	}
+	sorted := make([]diffKey, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Sort(diffKeys(sorted))
+
+	diffs := make([]ResourceDiff, 0)
+	for _, k := range sorted {
+		before, inFrom := fromRes[k]
+		after, inTo := toRes[k]
+		switch {
+		case !inFrom:
+			diffs = append(diffs, ResourceDiff{Resource: k.resource, Name: k.name, Change: DiffAdded})
+		case !inTo:
+			diffs = append(diffs, ResourceDiff{Resource: k.resource, Name: k.name, Change: DiffRemoved})
+		default:
+			if fields := diffFields(before, after, opts.Ignore); len(fields) > 0 {
+				diffs = append(diffs, ResourceDiff{Resource: k.resource, Name: k.name, Change: DiffChanged, Fields: fields})
+			}
+		}
+	}
+	return diffs, nil
+}
+
+// Drift fetches the zone described by conf, by the same means as FetchDefinition, and compares it with baseline.
+// When conf names no zone, the zone of the baseline is used.
+func Drift(conf Config, baseline *ZoneDefinition, opts DiffOptions) ([]ResourceDiff, error) {
+	if baseline == nil {
+		return nil, errors.New("baseline cannot be empty")
+	}
+	if conf.ZoneID == "" && conf.ZoneName == "" {
+		conf.ZoneID = baseline.Zone.Id
+	}
+	live, err := FetchDefinition(conf, nil)
+	if err != nil {
+		return nil, err
+	}
+	// the database details are supplied to a backup by hand and cannot drift
+	live.Database = baseline.Database
+	return Diff(baseline, live, opts)
+}
+
+// Compare fetches two zones, which may be managed by different management servers, and compares them with the
+// fields in ZoneSpecificFields ignored.  Pods, clusters, hosts and primary storage pools are not matched by name, but
+// compared as a "ZoneSummary" of the hypervisors, host tags and storage tags each zone has.
+func Compare(conf, otherConf Config, opts DiffOptions) ([]ResourceDiff, error) {
+	zd, err := FetchDefinition(conf, nil)
+	if err != nil {
+		return nil, err
+	}
+	other, err := FetchDefinition(otherConf, nil)
+	if err != nil {
+		return nil, err
+	}
+	return compareDefinitions(zd, other, opts)
+}
+
+func compareDefinitions(zd, other *ZoneDefinition, opts DiffOptions) ([]ResourceDiff, error) {
+	opts.Ignore = append(append([]string{}, opts.Ignore...), ZoneSpecificFields...)
+	diffs, err := Diff(withoutZoneNamed(zd), withoutZoneNamed(other), opts)
+	if err != nil {
+		return nil, err
+	}
+	// "ZoneSummary" sorts after every field of a definition, so the diffs remain in order
+	if fields := diffFields(zoneSummary(zd), zoneSummary(other), opts.Ignore); len(fields) > 0 {
+		diffs = append(diffs, ResourceDiff{Resource: "ZoneSummary", Name: other.Zone.Name, Change: DiffChanged, Fields: fields})
+	}
+	return diffs, nil
+}
+
+// withoutZoneNamed returns a shallow copy of a definition without its pods, clusters, hosts and primary storage pools,
+// which are usually named after their zone, e.g. "POD-EAST-1"
+func withoutZoneNamed(zd *ZoneDefinition) *ZoneDefinition {
+	c := *zd
+	c.Pods = nil
+	c.Clusters = nil
+	c.Hosts = nil
+	c.PrimaryStoragePools = nil
+	return &c
+}
+
+// zoneSummary lists the hypervisors, host tags and storage tags found in a zone, each sorted and without duplicates
+func zoneSummary(zd *ZoneDefinition) map[string]interface{} {
+	hypervisors := make(map[string]bool)
+	hostTags := make(map[string]bool)
+	storageTags := make(map[string]bool)
+	addTags := func(set map[string]bool, tags string) {
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				set[tag] = true
+			}
+		}
+	}
+	for _, cluster := range zd.Clusters {
+		if cluster.Hypervisortype != "" {
+			hypervisors[cluster.Hypervisortype] = true
+		}
+	}
+	for _, host := range zd.Hosts {
+		if host.Hypervisor != "" {
+			hypervisors[host.Hypervisor] = true
+		}
+		addTags(hostTags, host.Hosttags)
+	}
+	for _, pool := range zd.PrimaryStoragePools {
+		addTags(storageTags, pool.Tags)
+	}
+	return map[string]interface{}{
+		"hypervisors": summaryList(hypervisors),
+		"hosttags":    summaryList(hostTags),
+		"storagetags": summaryList(storageTags),
+	}
+}
+
+func summaryList(set map[string]bool) []string {
+	list := make([]string, 0, len(set))
+	for v := range set {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+type diffKey struct {
+	resource, name string
+}
+
+type diffKeys []diffKey
+
+func (s diffKeys) Len() int { return len(s) }
+func (s diffKeys) Less(i, j int) bool {
+	if s[i].resource != s[j].resource {
+		return s[i].resource < s[j].resource
+	}
+	return s[i].name < s[j].name
+}
+func (s diffKeys) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// definitionTree returns the generic JSON form of a definition, numbers being kept as written
+func definitionTree(zd *ZoneDefinition) (map[string]interface{}, error) {
+	b, err := json.Marshal(zd)
+	if err != nil {
+		return nil, err
+	}
+	tree := make(map[string]interface{})
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err = dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+// diffResources splits a definition into its individual resources, the zone itself being named zoneName
+func diffResources(zd *ZoneDefinition, zoneName string) (map[diffKey]map[string]interface{}, error) {
+	tree, err := definitionTree(zd)
+	if err != nil {
+		return nil, err
+	}
+	resources := make(map[diffKey]map[string]interface{})
+	for field, value := range tree {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		switch field {
+		case "Zone":
+			resources[diffKey{field, zoneName}] = obj
+			continue
+		case "Database":
+			resources[diffKey{field, field}] = obj
+			continue
+		}
+		for name, v := range obj {
+			res, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if child, ok := childResources[field]; ok {
+				if children, ok := res[child].(map[string]interface{}); ok {
+					for childName, cv := range children {
+						if c, ok := cv.(map[string]interface{}); ok {
+							resources[diffKey{child, name + "/" + childName}] = c
+						}
+					}
+				}
+				res = copyWithout(res, child)
+			}
+			resources[diffKey{field, name}] = res
+		}
+	}
+	return resources, nil
+}
+
+func copyWithout(obj map[string]interface{}, field string) map[string]interface{} {
+	c := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		if k != field {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+// diffFields compares two resources field by field.  Nested objects are compared field by field as well, lists
+// as a whole.
+func diffFields(before, after map[string]interface{}, ignore []string) []FieldDiff {
+	b := make(map[string]interface{})
+	a := make(map[string]interface{})
+	flatten(before, nil, ignore, b)
+	flatten(after, nil, ignore, a)
+
+	fields := make(map[string]bool, len(b)+len(a))
+	for f := range b {
+		fields[f] = true
+	}
+	for f := range a {
+		fields[f] = true
+	}
+	names := make([]string, 0, len(fields))
+	for f := range fields {
+		if !reflect.DeepEqual(b[f], a[f]) {
+			names = append(names, f)
+		}
+	}
+	sort.Strings(names)
+
+	diffs := make([]FieldDiff, len(names))
+	for i, f := range names {
+		diffs[i] = FieldDiff{Field: f, Before: b[f], After: a[f]}
+	}
+	return diffs
+}
+
+func flatten(obj map[string]interface{}, prefix []string, ignore []string, into map[string]interface{}) {
+	for k, v := range obj {
+		if ignored(k, ignore) {
+			continue
+		}
+		p := append(append([]string{}, prefix...), k)
+		if nested, ok := v.(map[string]interface{}); ok {
+			flatten(nested, p, ignore, into)
+			continue
+		}
+		into[strings.Join(p, ".")] = v
+	}
+}
+
+func ignored(field string, ignore []string) bool {
+	for _, pattern := range ignore {
+		if ok, _ := path.Match(pattern, field); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package definition
+
+import (
+	"fmt"
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"reflect"
+	"testing"
+)
+
+func TestCompareDefinitionsSummarisesZoneNamedResources(t *testing.T) {
+	east := NewZoneDefinition(cloudstack.Zone{Id: "e", Name: "east", Networktype: "Advanced"})
+	east.Pods["POD-EAST-1"] = cloudstack.Pod{Name: "POD-EAST-1"}
+	east.Clusters["CLUSTER-EAST-1"] = cloudstack.Cluster{Name: "CLUSTER-EAST-1", Hypervisortype: "KVM"}
+	east.Hosts["kvm-east-1"] = cloudstack.Host{Name: "kvm-east-1", Hypervisor: "KVM", Hosttags: "ssd,gpu"}
+	east.Hosts["kvm-east-2"] = cloudstack.Host{Name: "kvm-east-2", Hypervisor: "KVM", Hosttags: "ssd"}
+	east.PrimaryStoragePools["ps-east"] = cloudstack.StoragePool{Name: "ps-east", Tags: "fast"}
+	east.ComputeOfferings["small"] = cloudstack.ServiceOffering{Name: "small", Cpunumber: 1}
+
+	west := NewZoneDefinition(cloudstack.Zone{Id: "w", Name: "west", Networktype: "Advanced"})
+	west.Pods["POD-WEST-1"] = cloudstack.Pod{Name: "POD-WEST-1"}
+	west.Clusters["CLUSTER-WEST-1"] = cloudstack.Cluster{Name: "CLUSTER-WEST-1", Hypervisortype: "KVM"}
+	west.Hosts["kvm-west-1"] = cloudstack.Host{Name: "kvm-west-1", Hypervisor: "KVM", Hosttags: "gpu, ssd"}
+	west.PrimaryStoragePools["ps-west"] = cloudstack.StoragePool{Name: "ps-west", Tags: "fast"}
+	west.ComputeOfferings["small"] = cloudstack.ServiceOffering{Name: "small", Cpunumber: 1}
+
+	diffs, err := compareDefinitions(east, west, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Fatalf("zones configured alike differ: %+v", diffs)
+	}
+
+	west.Clusters["CLUSTER-WEST-2"] = cloudstack.Cluster{Name: "CLUSTER-WEST-2", Hypervisortype: "XenServer"}
+	west.PrimaryStoragePools["ps-west"] = cloudstack.StoragePool{Name: "ps-west", Tags: "slow"}
+	west.ComputeOfferings["small"] = cloudstack.ServiceOffering{Name: "small", Cpunumber: 2}
+	diffs, err = compareDefinitions(east, west, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 2 {
+		t.Fatalf("expected an offering and the zone summary to differ, got %+v", diffs)
+	}
+	if d := diffs[0]; d.Resource != "ComputeOfferings" || d.Name != "small" || len(d.Fields) != 1 ||
+		fmt.Sprint(d.Fields[0]) != "{cpunumber 1 2}" {
+		t.Errorf("unexpected offering diff %+v", d)
+	}
+	want := ResourceDiff{Resource: "ZoneSummary", Name: "west", Change: DiffChanged, Fields: []FieldDiff{
+		{Field: "hypervisors", Before: []string{"KVM"}, After: []string{"KVM", "XenServer"}},
+		{Field: "storagetags", Before: []string{"fast"}, After: []string{"slow"}},
+	}}
+	if !reflect.DeepEqual(diffs[1], want) {
+		t.Errorf("got summary %+v, want %+v", diffs[1], want)
+	}
+}
@@ -19,6 +19,7 @@ func init() {
 		new(FetchHosts),
 		new(FetchPrimaryStoragePools),
 		new(FetchSecondaryStoragePools),
+		new(FetchSecondaryStagingStores),
 		new(FetchPhysicalNetworks),
 		new(FetchComputeOfferings),
 		new(FetchDiskOfferings),
@@ -160,26 +161,81 @@ func (*FetchSecondaryStoragePools) Fetch(client *cloudstack.CloudStackClient, zd
 	if err != nil {
 		return err
 	}
+	regional, err := listRegionImageStores(client)
+	if err != nil {
+		return err
+	}
 	log.Println("Secondary (Image) Storage Pools fetched")
-	for _, pool := range pools.ImageStores {
+	for _, pool := range append(pools.ImageStores, regional...) {
 		zd.SecondaryStoragePools[pool.Name] = *pool
 		log.Println("  Pool: " + pool.Name)
 	}
 	return nil
 }
 
+// listRegionImageStores lists the image stores which serve every zone of the region, such as S3 and Swift stores.
+// These belong to no zone, so are never listed by zone.
+func listRegionImageStores(client *cloudstack.CloudStackClient) ([]*cloudstack.ImageStore, error) {
+	stores, err := client.ImageStore.ListImageStores(client.ImageStore.NewListImageStoresParams())
+	if err != nil {
+		return nil, err
+	}
+	regional := make([]*cloudstack.ImageStore, 0)
+	for _, store := range stores.ImageStores {
+		if isRegionImageStore(*store) {
+			regional = append(regional, store)
+		}
+	}
+	return regional, nil
+}
+
+func isRegionImageStore(store cloudstack.ImageStore) bool {
+	return store.Zoneid == "" || store.Scope == StorageScopeRegion
+}
+
+type FetchSecondaryStagingStores struct{}
+
+func (*FetchSecondaryStagingStores) Name() string {
+	return "secondaryStagingStores"
+}
+
+func (*FetchSecondaryStagingStores) Fetch(client *cloudstack.CloudStackClient, zd *ZoneDefinition) error {
+	log.Println("Fetching Secondary Staging (Cache) Stores...")
+	params := client.ImageStore.NewListSecondaryStagingStoresParams()
+	params.SetZoneid(zd.Zone.Id)
+	stores, err := client.ImageStore.ListSecondaryStagingStores(params)
+	if err != nil {
+		return err
+	}
+	log.Println("Secondary Staging (Cache) Stores fetched")
+	for _, store := range stores.SecondaryStagingStores {
+		zd.SecondaryStagingStores[store.Name] = *store
+		log.Println("  Store: " + store.Name)
+	}
+	return nil
+}
+
 type FetchPhysicalNetworks struct{}
 
 func (*FetchPhysicalNetworks) Name() string {
 	return "physicalNetworks"
 }
 
-func (*FetchPhysicalNetworks) expandTrafficType(client *cloudstack.CloudStackClient, zd *ZoneDefinition, csttype *cloudstack.TrafficType) (TrafficType, error) {
+type listTrafficTypesResponse struct {
+	Count        int `json:"count"`
+	TrafficTypes []*struct {
+		cloudstack.TrafficType
+		TrafficTypeLabels
+	} `json:"traffictype"`
+}
+
+func (*FetchPhysicalNetworks) expandTrafficType(client *cloudstack.CloudStackClient, zd *ZoneDefinition, csttype *cloudstack.TrafficType, labels TrafficTypeLabels) (TrafficType, error) {
 	var err error
 	var key string
 	log.Println("    Expanding Traffic Type " + csttype.TrafficType + "...")
 	ttype := &TrafficType{
 		TrafficType: *csttype,
+		Labels:      labels,
 		Networks:    make(map[string]cloudstack.Network),
 	}
 	log.Println("    Fetching Traffic Type " + csttype.TrafficType + " Networks...")
@@ -215,13 +271,16 @@ func (fpn *FetchPhysicalNetworks) expandPhysicalNetwork(client *cloudstack.Cloud
 	}
 
 	log.Println("  Fetching Physical Network " + cspn.Name + " Traffic Types...")
-	csttypes, err := client.Usage.ListTrafficTypes(client.Usage.NewListTrafficTypesParams(cspn.Id))
-	if err != nil {
+	// listTrafficTypes is called directly so the hypervisor network labels, unknown to the client, are kept
+	params := new(cloudstack.CustomServiceParams)
+	params.SetParam("physicalnetworkid", cspn.Id)
+	csttypes := new(listTrafficTypesResponse)
+	if err = client.Custom.CustomRequest("listTrafficTypes", params, csttypes); err != nil {
 		goto done
 	}
 	log.Println("  Physical Network " + cspn.Name + " Traffic Types fetched")
 	for _, csttype := range csttypes.TrafficTypes {
-		if ps.TrafficTypes[csttype.TrafficType], err = fpn.expandTrafficType(client, zd, csttype); err != nil {
+		if ps.TrafficTypes[csttype.TrafficType.TrafficType], err = fpn.expandTrafficType(client, zd, &csttype.TrafficType, csttype.TrafficTypeLabels); err != nil {
 			goto done
 		}
 	}
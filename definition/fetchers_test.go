@@ -0,0 +1,35 @@
+package definition
+
+import (
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReadmeFetchers(t *testing.T) {
+	b, err := ioutil.ReadFile("../README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	known := make(map[string]bool)
+	for _, name := range DefaultFetchers() {
+		known[name] = true
+	}
+	lists := regexp.MustCompile(`-fetch ([A-Za-z,]+)`).FindAllStringSubmatch(string(b), -1)
+	if len(lists) == 0 {
+		t.Fatal("no -fetch examples found in README.md")
+	}
+	for _, list := range lists {
+		for _, name := range strings.Split(list[1], ",") {
+			if !known[name] {
+				t.Errorf("README.md -fetch example names unknown fetcher %q", name)
+			}
+		}
+	}
+	for _, name := range CompareFetchers {
+		if !known[name] {
+			t.Errorf("CompareFetchers names unknown fetcher %q", name)
+		}
+	}
+}
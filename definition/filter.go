@@ -0,0 +1,257 @@
+package definition
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// RestoreFilter limits a restore to some of the resources in a definition.  Only and Skip are keyed by restore step
+// name, e.g. "pods", and hold glob patterns matched against the names of the resources that step restores.  An
+// empty pattern list matches every resource of the step.  Skip takes precedence over Only.
+type RestoreFilter struct {
+	Only map[string][]string `json:"only"`
+	Skip map[string][]string `json:"skip"`
+	// NoParents prevents the parents of resources selected by Only, such as the pod and zone of a selected
+	// cluster, from being selected along with them
+	NoParents bool `json:"noParents"`
+	// NoChildren prevents the resources belonging to pods and clusters selected by Only, such as the clusters,
+	// hosts and cluster scoped storage pools of a selected pod, from being selected along with them
+	NoChildren bool `json:"noChildren"`
+}
+
+// AddOnly parses a "step" or "step=pattern" selector into Only
+func (rf *RestoreFilter) AddOnly(selector string) error {
+	if rf.Only == nil {
+		rf.Only = make(map[string][]string)
+	}
+	return addSelector(rf.Only, selector)
+}
+
+// AddSkip parses a "step" or "step=pattern" selector into Skip
+func (rf *RestoreFilter) AddSkip(selector string) error {
+	if rf.Skip == nil {
+		rf.Skip = make(map[string][]string)
+	}
+	return addSelector(rf.Skip, selector)
+}
+
+func addSelector(selectors map[string][]string, selector string) error {
+	step, pattern := selector, ""
+	if i := strings.Index(selector, "="); i != -1 {
+		step, pattern = selector[:i], selector[i+1:]
+	}
+	if step == "" {
+		return fmt.Errorf("selector \"%s\" has no step name", selector)
+	}
+	if pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("selector \"%s\" has an invalid pattern: %s", selector, err)
+		}
+	}
+	patterns, ok := selectors[step]
+	switch {
+	case pattern == "":
+		// a bare step selects everything, overriding any patterns
+		selectors[step] = []string{}
+	case !ok:
+		selectors[step] = []string{pattern}
+	case len(patterns) > 0:
+		selectors[step] = append(patterns, pattern)
+	}
+	return nil
+}
+
+func matchSelector(selectors map[string][]string, step, name string) bool {
+	patterns, ok := selectors[step]
+	if !ok {
+		return false
+	}
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validate ensures every selector names a step of the plan
+func (rf RestoreFilter) validate(plan *RestorePlan) error {
+	unknown := make([]string, 0)
+	for _, selectors := range []map[string][]string{rf.Only, rf.Skip} {
+		for step := range selectors {
+			if _, ok := plan.steps[step]; !ok {
+				unknown = append(unknown, step)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("filter names unknown restore step(s): %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// restoreRelated holds the resources selected only because they are the parents or children of a resource selected
+// by a filter
+type restoreRelated struct {
+	names map[string]map[string]bool
+	// all holds steps every resource of which is required
+	all map[string]bool
+}
+
+func (rp *restoreRelated) add(step, name string) {
+	if rp.names[step] == nil {
+		rp.names[step] = make(map[string]bool)
+	}
+	rp.names[step][name] = true
+}
+
+func (rp *restoreRelated) has(step, name string) bool {
+	return rp.all[step] || rp.names[step][name]
+}
+
+// related finds the parents and children of every resource in the definition that Only selects
+func (rf RestoreFilter) related(zd *ZoneDefinition) *restoreRelated {
+	rp := &restoreRelated{names: make(map[string]map[string]bool), all: make(map[string]bool)}
+	if len(rf.Only) == 0 || zd == nil {
+		return rp
+	}
+	if !rf.NoChildren {
+		rf.children(zd, rp)
+	}
+	if !rf.NoParents {
+		rf.parents(zd, rp)
+	}
+	return rp
+}
+
+// children adds the clusters of selected pods, and the hosts and cluster scoped storage pools of selected clusters
+// and of the clusters of selected pods.  Resources excluded by Skip are not descended into.
+func (rf RestoreFilter) children(zd *ZoneDefinition, rp *restoreRelated) {
+	skipped := func(step, name string) bool {
+		return matchSelector(rf.Skip, step, name)
+	}
+
+	pods := make(map[string]bool)
+	for name := range zd.Pods {
+		if matchSelector(rf.Only, "pods", name) && !skipped("pods", name) {
+			pods[name] = true
+		}
+	}
+	clusters := make(map[string]bool)
+	for name, c := range zd.Clusters {
+		if skipped("clusters", name) {
+			continue
+		}
+		if pods[c.Podname] {
+			rp.add("clusters", name)
+			clusters[name] = true
+		} else if matchSelector(rf.Only, "clusters", name) {
+			clusters[name] = true
+		}
+	}
+	for name, host := range zd.Hosts {
+		if clusters[host.Clustername] {
+			rp.add("hosts", name)
+		}
+	}
+	for name, pool := range zd.PrimaryStoragePools {
+		if pool.Clustername != "" && clusters[pool.Clustername] {
+			rp.add("primaryStoragePools", name)
+		}
+	}
+}
+
+// parents adds the pods, zone and other resources that the resources selected by Only require
+func (rf RestoreFilter) parents(zd *ZoneDefinition, rp *restoreRelated) {
+	zone := func() {
+		rp.add("zone", zd.Zone.Name)
+	}
+	pod := func(name string) {
+		rp.add("pods", name)
+		zone()
+		rp.all["physicalNetworks"] = true
+	}
+	cluster := func(name string) {
+		rp.add("clusters", name)
+		pod(zd.Clusters[name].Podname)
+	}
+
+	selected := func(step, name string) bool {
+		return matchSelector(rf.Only, step, name) && !matchSelector(rf.Skip, step, name)
+	}
+
+	for name := range zd.PhysicalNetworks {
+		if selected("physicalNetworks", name) {
+			zone()
+		}
+	}
+	for name := range zd.Pods {
+		if selected("pods", name) {
+			pod(name)
+		}
+	}
+	for name, c := range zd.Clusters {
+		if selected("clusters", name) {
+			pod(c.Podname)
+		}
+	}
+	for name, host := range zd.Hosts {
+		if selected("hosts", name) {
+			cluster(host.Clustername)
+		}
+	}
+	for name, pool := range zd.PrimaryStoragePools {
+		if !selected("primaryStoragePools", name) {
+			continue
+		}
+		if pool.Clustername != "" {
+			cluster(pool.Clustername)
+		} else {
+			zone()
+		}
+	}
+	for name := range zd.SecondaryStoragePools {
+		if selected("secondaryStoragePools", name) {
+			zone()
+		}
+	}
+	for name := range zd.SecondaryStagingStores {
+		if selected("secondaryStagingStores", name) {
+			zone()
+		}
+	}
+	for name := range zd.ZoneConfiguration {
+		if selected("zoneConfigs", name) {
+			zone()
+		}
+	}
+	if selected("enableZone", zd.Zone.Name) {
+		zone()
+	}
+	for name := range zd.Templates {
+		if selected("templates", name) {
+			// templates are downloaded to the image stores of the zone, which must be enabled to do so
+			zone()
+			rp.add("enableZone", zd.Zone.Name)
+			rp.all["secondaryStoragePools"] = true
+		}
+	}
+}
+
+// Selected reports whether the current restore step should restore the named resource.  Restorers skip resources
+// which are not selected, though they may still look them up in the target.
+func (rs *Restoration) Selected(name string) bool {
+	if matchSelector(rs.filter.Skip, rs.step, name) {
+		return false
+	}
+	if len(rs.filter.Only) == 0 || matchSelector(rs.filter.Only, rs.step, name) {
+		return true
+	}
+	return rs.related != nil && rs.related.has(rs.step, name)
+}
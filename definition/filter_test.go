@@ -0,0 +1,220 @@
+package definition
+
+import (
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testFilterZone has two pods of two clusters each, every cluster with two hosts and a cluster scoped pool, and a
+// zone scoped pool
+func testFilterZone() *ZoneDefinition {
+	zd := NewZoneDefinition(cloudstack.Zone{Id: "source-zone", Name: "source"})
+	zd.PhysicalNetworks["physnet"] = PhysicalNetwork{PhysicalNetwork: cloudstack.PhysicalNetwork{Name: "physnet"}}
+	for _, pod := range []string{"A", "B"} {
+		zd.Pods["POD-"+pod] = cloudstack.Pod{Name: "POD-" + pod}
+		for _, c := range []string{"1", "2"} {
+			cluster := "CLUSTER-" + pod + c
+			zd.Clusters[cluster] = cloudstack.Cluster{Name: cluster, Podname: "POD-" + pod}
+			for _, h := range []string{"a", "b"} {
+				host := "host-" + pod + c + h
+				zd.Hosts[host] = cloudstack.Host{Name: host, Clustername: cluster, Podname: "POD-" + pod}
+			}
+			pool := "pool-" + pod + c
+			zd.PrimaryStoragePools[pool] = cloudstack.StoragePool{Name: pool, Clustername: cluster, Scope: "CLUSTER"}
+		}
+	}
+	zd.PrimaryStoragePools["pool-zone"] = cloudstack.StoragePool{Name: "pool-zone", Scope: "ZONE"}
+	return zd
+}
+
+// selected lists the names of the resources of a step that a restore with the filter would restore
+func selected(zd *ZoneDefinition, filter RestoreFilter, step string, names map[string]bool) string {
+	rs := &Restoration{filter: filter, related: filter.related(zd), step: step}
+	out := make([]string, 0)
+	for name := range names {
+		if rs.Selected(name) {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
+
+func testFilter(t *testing.T, only, skip []string) RestoreFilter {
+	filter := RestoreFilter{}
+	for _, s := range only {
+		if err := filter.AddOnly(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, s := range skip {
+		if err := filter.AddSkip(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filter
+}
+
+func TestRestoreFilterSelected(t *testing.T) {
+	zd := testFilterZone()
+	steps := map[string]map[string]bool{
+		"zone":                {zd.Zone.Name: true},
+		"physicalNetworks":    {},
+		"pods":                {},
+		"clusters":            {},
+		"hosts":               {},
+		"primaryStoragePools": {},
+	}
+	for name := range zd.PhysicalNetworks {
+		steps["physicalNetworks"][name] = true
+	}
+	for name := range zd.Pods {
+		steps["pods"][name] = true
+	}
+	for name := range zd.Clusters {
+		steps["clusters"][name] = true
+	}
+	for name := range zd.Hosts {
+		steps["hosts"][name] = true
+	}
+	for name := range zd.PrimaryStoragePools {
+		steps["primaryStoragePools"][name] = true
+	}
+
+	for _, tc := range []struct {
+		desc       string
+		only, skip []string
+		noParents  bool
+		noChildren bool
+		want       map[string]string
+	}{
+		{
+			desc: "pod selects its clusters, hosts and cluster pools, and its parents",
+			only: []string{"pods=POD-A"},
+			want: map[string]string{
+				"zone":                "source",
+				"physicalNetworks":    "physnet",
+				"pods":                "POD-A",
+				"clusters":            "CLUSTER-A1,CLUSTER-A2",
+				"hosts":               "host-A1a,host-A1b,host-A2a,host-A2b",
+				"primaryStoragePools": "pool-A1,pool-A2",
+			},
+		},
+		{
+			desc: "skip excludes a cluster of a selected pod and everything belonging to it",
+			only: []string{"pods=POD-A"},
+			skip: []string{"clusters=CLUSTER-A2"},
+			want: map[string]string{
+				"pods":                "POD-A",
+				"clusters":            "CLUSTER-A1",
+				"hosts":               "host-A1a,host-A1b",
+				"primaryStoragePools": "pool-A1",
+			},
+		},
+		{
+			desc: "skip excludes a host of a selected cluster",
+			only: []string{"clusters=CLUSTER-B1"},
+			skip: []string{"hosts=*b"},
+			want: map[string]string{
+				"zone":                "source",
+				"pods":                "POD-B",
+				"clusters":            "CLUSTER-B1",
+				"hosts":               "host-B1a",
+				"primaryStoragePools": "pool-B1",
+			},
+		},
+		{
+			desc:       "no-children selects the pod alone",
+			only:       []string{"pods=POD-A"},
+			noChildren: true,
+			want: map[string]string{
+				"zone":                "source",
+				"pods":                "POD-A",
+				"clusters":            "",
+				"hosts":               "",
+				"primaryStoragePools": "",
+			},
+		},
+		{
+			desc:      "no-parents leaves out the pod and zone of a selected host",
+			only:      []string{"hosts=host-A1a"},
+			noParents: true,
+			want: map[string]string{
+				"zone":     "",
+				"pods":     "",
+				"clusters": "",
+				"hosts":    "host-A1a",
+			},
+		},
+		{
+			desc: "a selected host selects its cluster and pod but not their other children",
+			only: []string{"hosts=host-A1a"},
+			want: map[string]string{
+				"zone":                "source",
+				"pods":                "POD-A",
+				"clusters":            "CLUSTER-A1",
+				"hosts":               "host-A1a",
+				"primaryStoragePools": "",
+			},
+		},
+		{
+			desc: "skip alone restores everything else",
+			skip: []string{"hosts"},
+			want: map[string]string{
+				"pods":                "POD-A,POD-B",
+				"hosts":               "",
+				"primaryStoragePools": "pool-A1,pool-A2,pool-B1,pool-B2,pool-zone",
+			},
+		},
+	} {
+		filter := testFilter(t, tc.only, tc.skip)
+		filter.NoParents, filter.NoChildren = tc.noParents, tc.noChildren
+		for step, want := range tc.want {
+			if got := selected(zd, filter, step, steps[step]); got != want {
+				t.Errorf("%s: %s selected %q, want %q", tc.desc, step, got, want)
+			}
+		}
+	}
+}
+
+func TestRestoreFilterAddSelector(t *testing.T) {
+	filter := RestoreFilter{}
+	for _, s := range []string{"pods=POD-A", "pods=POD-B", "hosts=kvm-*", "hosts"} {
+		if err := filter.AddOnly(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := strings.Join(filter.Only["pods"], ","); got != "POD-A,POD-B" {
+		t.Errorf("pods patterns %q", got)
+	}
+	// a bare step selects everything, whatever patterns were given before or after it
+	if err := filter.AddOnly("hosts=other"); err != nil {
+		t.Fatal(err)
+	}
+	if len(filter.Only["hosts"]) != 0 {
+		t.Errorf("hosts patterns %q, want none", filter.Only["hosts"])
+	}
+	for _, s := range []string{"=POD-A", "pods=[", ""} {
+		if err := filter.AddSkip(s); err == nil {
+			t.Errorf("expected an error adding %q", s)
+		}
+	}
+}
+
+func TestRestoreFilterValidate(t *testing.T) {
+	plan := NewRestorePlan(RegisteredRestorers()...)
+	filter := testFilter(t, []string{"pods=POD-A", "pod=POD-B"}, []string{"hosts", "host=x"})
+	err := filter.validate(plan)
+	if err == nil {
+		t.Fatal("expected unknown steps to be rejected")
+	}
+	if !strings.HasSuffix(err.Error(), ": host, pod") {
+		t.Errorf("unexpected error %q", err)
+	}
+
+	if err := testFilter(t, []string{"pods", "clusters=C", "templates"}, []string{"globalConfigs"}).validate(plan); err != nil {
+		t.Error(err)
+	}
+}
@@ -4,22 +4,51 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
 )
 
 type (
 	Formatter func(*ZoneDefinition) ([]byte, error)
+	Parser    func([]byte) (*ZoneDefinition, error)
 )
 
 var (
-	formattersMu sync.Mutex
-	formatters   map[string]Formatter
+	formattersMu      sync.Mutex
+	formatters        map[string]Formatter
+	builtinFormatters map[string]Formatter
+
+	parsersMu  sync.Mutex
+	parsers    map[string]Parser
+	extensions map[string]string
 )
 
 func init() {
-	formatters = map[string]Formatter{
+	builtinFormatters = map[string]Formatter{
 		"json":        FormatJSON,
 		"json-indent": FormatJSONIndent,
+		"yaml":        FormatYAML,
+
+		"terraform":        FormatTerraform,
+		"terraform-import": FormatTerraformImport,
+	}
+	formatters = make(map[string]Formatter, len(builtinFormatters))
+	for name, fn := range builtinFormatters {
+		formatters[name] = fn
+	}
+	parsers = map[string]Parser{
+		"json":        ParseJSON,
+		"json-indent": ParseJSON,
+		"yaml":        ParseYAML,
+	}
+	extensions = map[string]string{
+		".json": "json",
+		".yaml": "yaml",
+		".yml":  "yaml",
 	}
 }
 
@@ -37,12 +66,70 @@ func FormatJSONIndent(zd *ZoneDefinition) ([]byte, error) {
 	return json.MarshalIndent(zd, "", "\t")
 }
 
+// ParseJSON reconstructs a ZoneDefinition from the output of either FormatJSON or FormatJSONIndent
+func ParseJSON(b []byte) (*ZoneDefinition, error) {
+	if len(b) == 0 {
+		return nil, errors.New("input cannot be empty")
+	}
+	top := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(b, &top); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(top))
+	for key := range top {
+		keys = append(keys, key)
+	}
+	if err := checkDefinitionKeys(keys); err != nil {
+		return nil, err
+	}
+	zd := NewZoneDefinition(cloudstack.Zone{})
+	if err := json.Unmarshal(b, zd); err != nil {
+		return nil, err
+	}
+	return zd, nil
+}
+
+// checkDefinitionKeys ensures the top level keys of a document are those of a ZoneDefinition, which always has a Zone.
+// Keys are matched case insensitively, as encoding/json matches them.
+func checkDefinitionKeys(keys []string) error {
+	for _, key := range keys {
+		if strings.EqualFold(key, "Zone") {
+			return nil
+		}
+	}
+	return errors.New("document has no Zone and is not a zone definition")
+}
+
 func SetFormatter(name string, fn Formatter) {
 	formattersMu.Lock()
 	formatters[name] = fn
 	formattersMu.Unlock()
 }
 
+// IsBuiltinFormatter reports whether name is a built in format whose formatter has not been replaced with SetFormatter
+func IsBuiltinFormatter(name string) bool {
+	builtin, ok := builtinFormatters[name]
+	if !ok {
+		return false
+	}
+	formattersMu.Lock()
+	fn := formatters[name]
+	formattersMu.Unlock()
+	return fn != nil && reflect.ValueOf(fn).Pointer() == reflect.ValueOf(builtin).Pointer()
+}
+
+// Formats returns the names of the registered formatters, sorted
+func Formats() []string {
+	formattersMu.Lock()
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+	formattersMu.Unlock()
+	sort.Strings(names)
+	return names
+}
+
 func Format(zd *ZoneDefinition, format string) ([]byte, error) {
 	formattersMu.Lock()
 	fn, ok := formatters[format]
@@ -53,3 +140,79 @@ func Format(zd *ZoneDefinition, format string) ([]byte, error) {
 	formattersMu.Unlock()
 	return fn(zd)
 }
+
+func SetParser(name string, fn Parser) {
+	parsersMu.Lock()
+	parsers[name] = fn
+	parsersMu.Unlock()
+}
+
+// SetParserExtension makes files ending in ext, e.g. ".yml", parse with the named parser.  A file ending in the name of
+// a parser, e.g. ".yaml", needs no extension set.
+func SetParserExtension(ext, name string) {
+	parsersMu.Lock()
+	extensions[strings.ToLower(ext)] = name
+	parsersMu.Unlock()
+}
+
+func Parse(b []byte, format string) (*ZoneDefinition, error) {
+	parsersMu.Lock()
+	fn, ok := parsers[format]
+	if !ok {
+		parsersMu.Unlock()
+		return nil, fmt.Errorf("no parser named \"%s\" found", format)
+	}
+	parsersMu.Unlock()
+	return fn(b)
+}
+
+// ParseFile parses the contents of filename with the parser its extension names.  When the extension names no
+// parser, the content is sniffed by trying each parser in turn, json first, and the first to succeed is used.  The
+// name of the parser used is returned along with the definition.
+func ParseFile(filename string, b []byte) (*ZoneDefinition, string, error) {
+	ext := strings.ToLower(path.Ext(filename))
+
+	parsersMu.Lock()
+	format, ok := extensions[ext]
+	if !ok && ext != "" {
+		if _, ok = parsers[ext[1:]]; ok {
+			format = ext[1:]
+		}
+	}
+	names := make([]string, 0, len(parsers))
+	for name := range parsers {
+		if name != "json" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	names = append([]string{"json"}, names...)
+	// formats sharing a parser, such as json and json-indent, are only tried once
+	fns := make([]Parser, 0, len(names))
+	tried := make(map[uintptr]bool, len(names))
+	for _, name := range names {
+		fn := parsers[name]
+		if p := reflect.ValueOf(fn).Pointer(); !tried[p] {
+			tried[p] = true
+			fns = append(fns, fn)
+			names[len(fns)-1] = name
+		}
+	}
+	names = names[:len(fns)]
+	parsersMu.Unlock()
+
+	if ok {
+		zd, err := Parse(b, format)
+		return zd, format, err
+	}
+
+	errs := make([]string, 0, len(fns))
+	for i, fn := range fns {
+		zd, err := fn(b)
+		if err == nil {
+			return zd, names[i], nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %s", names[i], err))
+	}
+	return nil, "", fmt.Errorf("unable to detect format of \"%s\" (%s)", filename, strings.Join(errs, "; "))
+}
@@ -0,0 +1,63 @@
+package definition
+
+import (
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"testing"
+)
+
+func TestParseFile(t *testing.T) {
+	zd := NewZoneDefinition(cloudstack.Zone{Id: "z1", Name: "zone"})
+	for _, format := range []string{"json", "yaml"} {
+		b, err := Format(zd, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, filename := range []string{"zone." + format, "zone.txt", "zone"} {
+			parsed, used, err := ParseFile(filename, b)
+			if err != nil {
+				t.Errorf("%s as %s: %s", format, filename, err)
+				continue
+			}
+			if used != format || parsed.Zone.Name != "zone" {
+				t.Errorf("%s as %s: parsed by %s, zone %+v", format, filename, used, parsed.Zone)
+			}
+		}
+	}
+}
+
+func TestParseFileNotDefinition(t *testing.T) {
+	for _, tc := range []struct {
+		filename string
+		content  string
+	}{
+		{"x.txt", "hello: world"},
+		{"x.txt", `{"hello": "world"}`},
+		{"x.txt", `{}`},
+		{"x.txt", `["Zone"]`},
+		{"x.json", `{"hello": "world"}`},
+		{"x.json", `{"Pods": {}}`},
+		{"x.yaml", "hello: world"},
+	} {
+		if _, format, err := ParseFile(tc.filename, []byte(tc.content)); err == nil {
+			t.Errorf("%s %q parsed as %s", tc.filename, tc.content, format)
+		}
+	}
+}
+
+func TestIsBuiltinFormatter(t *testing.T) {
+	if !IsBuiltinFormatter("json") || !IsBuiltinFormatter("yaml") {
+		t.Error("built in formatters not reported as such")
+	}
+	if IsBuiltinFormatter("JSON") || IsBuiltinFormatter("toml") {
+		t.Error("unknown formatter reported as built in")
+	}
+
+	SetFormatter("json", FormatJSONIndent)
+	defer SetFormatter("json", FormatJSON)
+	if IsBuiltinFormatter("json") {
+		t.Error("replaced json formatter reported as built in")
+	}
+	if !IsBuiltinFormatter("json-indent") {
+		t.Error("json-indent no longer reported as built in")
+	}
+}
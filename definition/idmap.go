@@ -0,0 +1,109 @@
+package definition
+
+import (
+	"sort"
+)
+
+type (
+	// IDMapping pairs the id of a resource in the source zone with the id of the same resource in the target
+	IDMapping struct {
+		Name     string `json:"name"`
+		SourceID string `json:"sourceId"`
+		TargetID string `json:"targetId"`
+	}
+
+	// IDMap holds the IDMappings of a restore, keyed by the ZoneDefinition field the resources are found in,
+	// e.g. "Pods".  Traffic types are found under "TrafficTypes" and named "<physical network>/<traffic type>".
+	// The domains and os types that restored resources reference are found under "Domains" and "OsTypes".
+	IDMap map[string][]IDMapping
+)
+
+func (m IDMap) add(resource, name, sourceID, targetID string) {
+	if sourceID == "" || targetID == "" || isPlaceholderID(targetID) {
+		return
+	}
+	m[resource] = append(m[resource], IDMapping{Name: name, SourceID: sourceID, TargetID: targetID})
+}
+
+// Lookup returns the target id of a resource given its source id
+func (m IDMap) Lookup(resource, sourceID string) (string, bool) {
+	for _, mapping := range m[resource] {
+		if mapping.SourceID == sourceID {
+			return mapping.TargetID, true
+		}
+	}
+	return "", false
+}
+
+// IDMap pairs every resource of the source definition with the resource of the same name in the target.
+// Resources which were skipped, or not restored at all, are left out.
+func (rs *Restoration) IDMap() IDMap {
+	m := make(IDMap)
+
+	m.add("Zone", rs.Target.Zone.Name, rs.Source.Zone.Id, rs.Target.Zone.Id)
+	for name, src := range rs.Source.PhysicalNetworks {
+		target := rs.Target.PhysicalNetworks[name]
+		m.add("PhysicalNetworks", name, src.Id, target.Id)
+		for ttName, tt := range src.TrafficTypes {
+			m.add("TrafficTypes", name+"/"+ttName, tt.Id, target.TrafficTypes[ttName].Id)
+		}
+	}
+	for name, src := range rs.Source.Pods {
+		m.add("Pods", name, src.Id, rs.Target.Pods[name].Id)
+	}
+	for name, src := range rs.Source.Clusters {
+		m.add("Clusters", name, src.Id, rs.Target.Clusters[name].Id)
+	}
+	for name, src := range rs.Source.Hosts {
+		m.add("Hosts", name, src.Id, rs.Target.Hosts[name].Id)
+	}
+	for name, src := range rs.Source.PrimaryStoragePools {
+		m.add("PrimaryStoragePools", name, src.Id, rs.Target.PrimaryStoragePools[name].Id)
+	}
+	for name, src := range rs.Source.SecondaryStoragePools {
+		m.add("SecondaryStoragePools", name, src.Id, rs.Target.SecondaryStoragePools[name].Id)
+	}
+	for name, src := range rs.Source.SecondaryStagingStores {
+		m.add("SecondaryStagingStores", name, src.Id, rs.Target.SecondaryStagingStores[name].Id)
+	}
+	for name, src := range rs.Source.ComputeOfferings {
+		m.add("ComputeOfferings", name, src.Id, rs.Target.ComputeOfferings[name].Id)
+	}
+	for name, src := range rs.Source.DiskOfferings {
+		m.add("DiskOfferings", name, src.Id, rs.Target.DiskOfferings[name].Id)
+	}
+	for name, src := range rs.Source.Templates {
+		m.add("Templates", name, src.Id, rs.Target.Templates[name].Id)
+	}
+
+	// domains and os types are only known to the target once something restored has referenced them
+	domains := make(map[string]string)
+	osTypes := make(map[string]string)
+	for _, src := range rs.Source.ComputeOfferings {
+		domains[src.Domain] = src.Domainid
+	}
+	for _, src := range rs.Source.DiskOfferings {
+		domains[src.Domain] = src.Domainid
+	}
+	for _, src := range rs.Source.Templates {
+		domains[src.Domain] = src.Domainid
+		osTypes[src.Ostypename] = src.Ostypeid
+	}
+	for name, sourceID := range domains {
+		m.add("Domains", name, sourceID, rs.domains[name])
+	}
+	for name, sourceID := range osTypes {
+		m.add("OsTypes", name, sourceID, rs.osTypes[name])
+	}
+
+	for _, mappings := range m {
+		sort.Sort(idMappingsByName(mappings))
+	}
+	return m
+}
+
+type idMappingsByName []IDMapping
+
+func (s idMappingsByName) Len() int           { return len(s) }
+func (s idMappingsByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s idMappingsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,181 @@
+package definition
+
+import (
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"path"
+	"strings"
+)
+
+// DefaultConfigurationDeny lists the configuration keys that describe a specific environment and should not be
+// pushed to another.  ConfigurationFilter denies these along with its own Deny list unless told otherwise.
+var DefaultConfigurationDeny = []string{
+	"host",
+	"management.network.cidr",
+	"*secret*",
+	"*password*",
+	"*.key",
+	"*keystore*",
+}
+
+type (
+	// ClusterParameters holds the values needed to add a cluster that CloudStack does not return, and that
+	// are therefore not present in a backup.
+	ClusterParameters struct {
+		URL               string `json:"url"`
+		Username          string `json:"username"`
+		Password          string `json:"password"`
+		GuestVSwitchName  string `json:"guestVSwitchName"`
+		GuestVSwitchType  string `json:"guestVSwitchType"`
+		PublicVSwitchName string `json:"publicVSwitchName"`
+		PublicVSwitchType string `json:"publicVSwitchType"`
+		VSMIPAddress      string `json:"vsmIPAddress"`
+		VSMUsername       string `json:"vsmUsername"`
+		VSMPassword       string `json:"vsmPassword"`
+		OVM3Pool          string `json:"ovm3Pool"`
+		OVM3Cluster       string `json:"ovm3Cluster"`
+	}
+
+	// ClusterParameterSet allows ClusterParameters to be defined per hypervisor type, with per cluster
+	// overrides.  VMware clusters will generally need a cluster entry, as the vCenter URL includes the
+	// cluster name.
+	ClusterParameterSet struct {
+		Hypervisors map[string]ClusterParameters `json:"hypervisors"`
+		Clusters    map[string]ClusterParameters `json:"clusters"`
+	}
+
+	HostCredentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	// HostCredentialSet is keyed by host name or ip address.  The key "*" is used for any host without its
+	// own entry.
+	HostCredentialSet map[string]HostCredentials
+
+	// StorageRewrites maps the url, or url prefix, of storage in the source zone to the url to use in the
+	// target zone, e.g. "nfs://10.0.0.5/export/primary" => "nfs://10.1.0.5/export/primary".
+	StorageRewrites map[string]string
+
+	// StorageURLs holds the url of primary storage pools keyed by pool name, for pools of a type whose url cannot
+	// be rebuilt from a backup.  These urls are used as given, without StorageRewrites being applied.
+	StorageURLs map[string]string
+
+	// ImageStoreSecrets holds the provider details of image and staging stores, keyed by store name.  These
+	// are things like the access and secret keys of an S3 store, which CloudStack never returns.
+	ImageStoreSecrets map[string]map[string]string
+
+	// NetworkLabelMap maps the hypervisor network labels of the source zone to those to use in the target
+	// zone, e.g. "cloudbr0" => "br-mgmt"
+	NetworkLabelMap map[string]string
+
+	// ConfigurationFilter limits which configuration keys are restored.  Both lists hold glob patterns, an empty
+	// Allow list allowing everything.  Deny takes precedence, and is added to DefaultConfigurationDeny unless
+	// NoDefaultDeny is set.
+	ConfigurationFilter struct {
+		Allow         []string `json:"allow"`
+		Deny          []string `json:"deny"`
+		NoDefaultDeny bool     `json:"noDefaultDeny"`
+	}
+
+	// TemplateURLs holds the download url of templates, keyed by template name or source template id
+	TemplateURLs map[string]string
+
+	// RestoreInputs are the values a restore needs which cannot be captured by a backup
+	RestoreInputs struct {
+		ClusterParameters ClusterParameterSet `json:"clusterParameters"`
+		HostCredentials   HostCredentialSet   `json:"hostCredentials"`
+		StorageRewrites   StorageRewrites     `json:"storageRewrites"`
+		StorageURLs       StorageURLs         `json:"storageURLs"`
+		ImageStoreSecrets ImageStoreSecrets   `json:"imageStoreSecrets"`
+		NetworkLabels     NetworkLabelMap     `json:"networkLabels"`
+		Configuration     ConfigurationFilter `json:"configuration"`
+		TemplateURLs      TemplateURLs        `json:"templateURLs"`
+	}
+)
+
+// For returns the parameters to use for the provided cluster, if any are defined
+func (cps ClusterParameterSet) For(cluster cloudstack.Cluster) (ClusterParameters, bool) {
+	if p, ok := cps.Clusters[cluster.Name]; ok {
+		return p, true
+	}
+	p, ok := cps.Hypervisors[cluster.Hypervisortype]
+	return p, ok
+}
+
+// For returns the credentials to use for the provided host, if any are defined
+func (hcs HostCredentialSet) For(host cloudstack.Host) (HostCredentials, bool) {
+	if c, ok := hcs[host.Name]; ok {
+		return c, true
+	}
+	if c, ok := hcs[host.Ipaddress]; ok {
+		return c, true
+	}
+	c, ok := hcs["*"]
+	return c, ok
+}
+
+// Rewrite returns the provided url with the longest matching prefix replaced.  A prefix only matches whole
+// path segments, so "nfs://10.0.0.5" will not match "nfs://10.0.0.50/export".
+func (sr StorageRewrites) Rewrite(url string) string {
+	var match string
+	for from := range sr {
+		if !strings.HasPrefix(url, from) || len(from) <= len(match) {
+			continue
+		}
+		if rest := url[len(from):]; rest == "" || rest[0] == '/' || strings.HasSuffix(from, "/") {
+			match = from
+		}
+	}
+	if match == "" {
+		return url
+	}
+	return sr[match] + url[len(match):]
+}
+
+// Remap returns a copy of the provided labels with each mapped label replaced
+func (nlm NetworkLabelMap) Remap(labels TrafficTypeLabels) TrafficTypeLabels {
+	remap := func(label string) string {
+		if to, ok := nlm[label]; ok {
+			return to
+		}
+		return label
+	}
+	return TrafficTypeLabels{
+		HyperV:    remap(labels.HyperV),
+		KVM:       remap(labels.KVM),
+		OVM3:      remap(labels.OVM3),
+		VMware:    remap(labels.VMware),
+		XenServer: remap(labels.XenServer),
+	}
+}
+
+// Allowed reports whether the configuration key may be restored
+func (cf ConfigurationFilter) Allowed(name string) bool {
+	deny := cf.Deny
+	if !cf.NoDefaultDeny {
+		deny = append(append([]string{}, DefaultConfigurationDeny...), cf.Deny...)
+	}
+	for _, pattern := range deny {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(cf.Allow) == 0 {
+		return true
+	}
+	for _, pattern := range cf.Allow {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// For returns the download url of the provided template, if one is defined
+func (tu TemplateURLs) For(template cloudstack.Template) (string, bool) {
+	if url, ok := tu[template.Name]; ok {
+		return url, true
+	}
+	url, ok := tu[template.Id]
+	return url, ok
+}
@@ -0,0 +1,33 @@
+package definition
+
+import (
+	"testing"
+)
+
+func TestConfigurationFilterAllowed(t *testing.T) {
+	for _, tc := range []struct {
+		filter ConfigurationFilter
+		name   string
+		want   bool
+	}{
+		// the default deny list applies to a library caller's empty filter
+		{ConfigurationFilter{}, "host", false},
+		{ConfigurationFilter{}, "security.encryption.key", false},
+		{ConfigurationFilter{}, "router.ram.secret", false},
+		{ConfigurationFilter{}, "vm.allocation.algorithm", true},
+		// Deny adds to the defaults rather than replacing them
+		{ConfigurationFilter{Deny: []string{"vm.*"}}, "vm.allocation.algorithm", false},
+		{ConfigurationFilter{Deny: []string{"vm.*"}}, "host", false},
+		{ConfigurationFilter{Deny: []string{"vm.*"}}, "storage.cleanup.enabled", true},
+		// Allow cannot override a deny
+		{ConfigurationFilter{Allow: []string{"*"}}, "host", false},
+		{ConfigurationFilter{Allow: []string{"vm.*"}}, "storage.cleanup.enabled", false},
+		// the defaults only stop applying when explicitly told to
+		{ConfigurationFilter{NoDefaultDeny: true}, "host", true},
+		{ConfigurationFilter{NoDefaultDeny: true, Deny: []string{"host"}}, "host", false},
+	} {
+		if got := tc.filter.Allowed(tc.name); got != tc.want {
+			t.Errorf("%+v.Allowed(%q) = %t, want %t", tc.filter, tc.name, got, tc.want)
+		}
+	}
+}
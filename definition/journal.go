@@ -0,0 +1,238 @@
+package definition
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"io"
+)
+
+// deleteCommands maps each api call that creates a resource to the call which removes it again
+var deleteCommands = map[string]string{
+	"createZone":                  "deleteZone",
+	"createPhysicalNetwork":       "deletePhysicalNetwork",
+	"addTrafficType":              "deleteTrafficType",
+	"createPod":                   "deletePod",
+	"addCluster":                  "deleteCluster",
+	"addHost":                     "deleteHost",
+	"createStoragePool":           "deleteStoragePool",
+	"addImageStore":               "deleteImageStore",
+	"createSecondaryStagingStore": "deleteSecondaryStagingStore",
+	"createServiceOffering":       "deleteServiceOffering",
+	"createDiskOffering":          "deleteDiskOffering",
+	"registerTemplate":            "deleteTemplate",
+}
+
+// JournalEntry records a resource created by a restore along with the api call that will remove it
+type JournalEntry struct {
+	Step     string            `json:"step"`
+	Resource string            `json:"resource"`
+	Name     string            `json:"name"`
+	ID       string            `json:"id"`
+	Command  string            `json:"command"`
+	Params   map[string]string `json:"params"`
+}
+
+// journal adds an entry for a newly created resource, writing it out immediately so that it survives a crash
+func (rs *Restoration) journal(res RestoreResult) error {
+	command, ok := deleteCommands[res.Command]
+	if !ok {
+		return nil
+	}
+	entry := JournalEntry{
+		Step:     rs.step,
+		Resource: res.Resource,
+		Name:     res.Name,
+		ID:       res.ID,
+		Command:  command,
+		Params:   map[string]string{"id": res.ID},
+	}
+	switch command {
+	case "deleteHost", "deleteStoragePool":
+		entry.Params["forced"] = "true"
+	case "deleteTemplate":
+		entry.Params["zoneid"] = rs.Target.Zone.Id
+	}
+	rs.Journal = append(rs.Journal, entry)
+	if rs.journalWriter == nil {
+		return nil
+	}
+	if err := WriteJournal(rs.journalWriter, []JournalEntry{entry}); err != nil {
+		return fmt.Errorf("unable to write journal entry for %s %s: %s", res.Resource, res.Name, err)
+	}
+	return nil
+}
+
+// rollback deletes every journaled resource, newest first.  As resources are journaled in the order the plan
+// created them, this removes children before their parents.  Entries are removed from the journal as they are
+// deleted, so on error the journal holds only what remains.
+func (rs *Restoration) rollback(client *cloudstack.CloudStackClient) error {
+	rs.step = "rollback"
+	for len(rs.Journal) > 0 {
+		entry := rs.Journal[len(rs.Journal)-1]
+		res := RestoreResult{
+			Resource: entry.Resource,
+			Name:     entry.Name,
+			Status:   RestoreStatusDeleted,
+			ID:       entry.ID,
+			Command:  entry.Command,
+			Params:   entry.Params,
+		}
+		if !rs.Options.DryRun {
+			if err := undo(client, entry); err != nil {
+				return fmt.Errorf("unable to delete %s %s: %s", entry.Resource, entry.Name, err)
+			}
+		}
+		rs.add(res)
+		rs.Journal = rs.Journal[:len(rs.Journal)-1]
+	}
+	return nil
+}
+
+// stepTargets clears the part of the target each built in step fills in.  The name of a zone is kept, so that a
+// zone which was rolled back is created again under the same name.
+var stepTargets = map[string]func(*ZoneDefinition){
+	"zone":                   func(t *ZoneDefinition) { t.Zone = cloudstack.Zone{Name: t.Zone.Name} },
+	"physicalNetworks":       func(t *ZoneDefinition) { t.PhysicalNetworks = make(map[string]PhysicalNetwork) },
+	"pods":                   func(t *ZoneDefinition) { t.Pods = make(map[string]cloudstack.Pod) },
+	"clusters":               func(t *ZoneDefinition) { t.Clusters = make(map[string]cloudstack.Cluster) },
+	"hosts":                  func(t *ZoneDefinition) { t.Hosts = make(map[string]cloudstack.Host) },
+	"primaryStoragePools":    func(t *ZoneDefinition) { t.PrimaryStoragePools = make(map[string]cloudstack.StoragePool) },
+	"secondaryStoragePools":  func(t *ZoneDefinition) { t.SecondaryStoragePools = make(map[string]cloudstack.ImageStore) },
+	"secondaryStagingStores": func(t *ZoneDefinition) { t.SecondaryStagingStores = make(map[string]cloudstack.SecondaryStagingStore) },
+	"computeOfferings":       func(t *ZoneDefinition) { t.ComputeOfferings = make(map[string]cloudstack.ServiceOffering) },
+	"diskOfferings":          func(t *ZoneDefinition) { t.DiskOfferings = make(map[string]cloudstack.DiskOffering) },
+	"templates":              func(t *ZoneDefinition) { t.Templates = make(map[string]cloudstack.Template) },
+	"zoneConfigs":            func(t *ZoneDefinition) { t.ZoneConfiguration = make(map[string]cloudstack.Configuration) },
+	"globalConfigs":          func(t *ZoneDefinition) { t.GlobalConfiguration = make(map[string]cloudstack.Configuration) },
+}
+
+// reopen undoes the completion of the steps which created the resources in entries, once rollback has deleted
+// them, along with every step of the plan depending on those steps.  What the reopened steps put in the target is
+// cleared, so a restore resumed from a checkpoint taken afterwards runs them again in full.  Rolling back a zone,
+// for instance, reopens the configuration and enabling of the zone as well as everything created within it.
+func (rs *Restoration) reopen(plan *RestorePlan, entries []JournalEntry) {
+	reopened := make(map[string]bool)
+	for _, entry := range entries {
+		if reopened[entry.Step] {
+			continue
+		}
+		reopened[entry.Step] = true
+		for _, name := range plan.dependents(entry.Step) {
+			reopened[name] = true
+		}
+	}
+
+	completed := make([]string, 0, len(rs.Completed))
+	for _, name := range rs.Completed {
+		if !reopened[name] {
+			completed = append(completed, name)
+		}
+	}
+	rs.Completed = completed
+
+	for name := range reopened {
+		if reset, ok := stepTargets[name]; ok {
+			reset(rs.Target)
+		}
+	}
+	if reopened["zone"] {
+		rs.ZoneID = ""
+	}
+}
+
+// undo makes the delete call recorded in a journal entry
+func undo(client *cloudstack.CloudStackClient, entry JournalEntry) error {
+	var err error
+	switch entry.Command {
+	case "deleteZone":
+		_, err = client.Zone.DeleteZone(client.Zone.NewDeleteZoneParams(entry.ID))
+	case "deletePhysicalNetwork":
+		_, err = client.Network.DeletePhysicalNetwork(client.Network.NewDeletePhysicalNetworkParams(entry.ID))
+	case "deleteTrafficType":
+		_, err = client.Usage.DeleteTrafficType(client.Usage.NewDeleteTrafficTypeParams(entry.ID))
+	case "deletePod":
+		_, err = client.Pod.DeletePod(client.Pod.NewDeletePodParams(entry.ID))
+	case "deleteCluster":
+		_, err = client.Cluster.DeleteCluster(client.Cluster.NewDeleteClusterParams(entry.ID))
+	case "deleteHost":
+		params := client.Host.NewDeleteHostParams(entry.ID)
+		params.SetForced(entry.Params["forced"] == "true")
+		_, err = client.Host.DeleteHost(params)
+	case "deleteStoragePool":
+		params := client.Pool.NewDeleteStoragePoolParams(entry.ID)
+		params.SetForced(entry.Params["forced"] == "true")
+		_, err = client.Pool.DeleteStoragePool(params)
+	case "deleteImageStore":
+		_, err = client.ImageStore.DeleteImageStore(client.ImageStore.NewDeleteImageStoreParams(entry.ID))
+	case "deleteSecondaryStagingStore":
+		_, err = client.ImageStore.DeleteSecondaryStagingStore(client.ImageStore.NewDeleteSecondaryStagingStoreParams(entry.ID))
+	case "deleteServiceOffering":
+		_, err = client.ServiceOffering.DeleteServiceOffering(client.ServiceOffering.NewDeleteServiceOfferingParams(entry.ID))
+	case "deleteDiskOffering":
+		_, err = client.DiskOffering.DeleteDiskOffering(client.DiskOffering.NewDeleteDiskOfferingParams(entry.ID))
+	case "deleteTemplate":
+		params := client.Template.NewDeleteTemplateParams(entry.ID)
+		if zoneID := entry.Params["zoneid"]; zoneID != "" {
+			params.SetZoneid(zoneID)
+		}
+		_, err = client.Template.DeleteTemplate(params)
+	default:
+		err = fmt.Errorf("unknown command \"%s\"", entry.Command)
+	}
+	return err
+}
+
+// ParseJournal reads the entries written by a restore journal, one JSON object per line
+func ParseJournal(b []byte) ([]JournalEntry, error) {
+	entries := make([]JournalEntry, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry := JournalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("journal line %d: %s", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// WriteJournal writes entries in the format read by ParseJournal
+func WriteJournal(w io.Writer, entries []JournalEntry) error {
+	for _, entry := range entries {
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err = w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RollbackJournal deletes the resources recorded in a journal, newest first.  The returned Restoration holds a
+// result per deleted resource, and in its Journal the entries that could not be rolled back.
+func RollbackJournal(conf RestoreConfig, entries []JournalEntry) (*Restoration, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("journal cannot be empty")
+	}
+
+	client, err := newClient(conf.Key, conf.Secret, conf.Scheme, conf.Host, conf.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := NewRestoration(nil)
+	rs.Options = conf.Options
+	rs.Journal = append(rs.Journal, entries...)
+
+	log.Printf("Rolling back %d resource(s)...", len(entries))
+	return rs, rs.rollback(client)
+}
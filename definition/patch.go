@@ -0,0 +1,292 @@
+package definition
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	PatchAdd     = "add"
+	PatchRemove  = "remove"
+	PatchReplace = "replace"
+)
+
+// PatchOperation is a single RFC 6902 JSON Patch operation
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON writes the value of add and replace operations even when it is null, as RFC 6902 requires, and
+// leaves it out of remove operations
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	if op.Op == PatchRemove {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{op.Op, op.Path})
+	}
+	return json.Marshal(struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}{op.Op, op.Path, op.Value})
+}
+
+// nameKeyed lists the paths within a definition of the maps keyed by resource name, "*" matching any name.  The
+// keys of these maps are never matched against ignore patterns.
+var nameKeyed = [][]string{
+	{"Pods"},
+	{"Clusters"},
+	{"Hosts"},
+	{"PrimaryStoragePools"},
+	{"SecondaryStoragePools"},
+	{"SecondaryStagingStores"},
+	{"PhysicalNetworks"},
+	{"PhysicalNetworks", "*", "TrafficTypes"},
+	{"PhysicalNetworks", "*", "TrafficTypes", "*", "Networks"},
+	{"ComputeOfferings"},
+	{"DiskOfferings"},
+	{"Templates"},
+	{"GlobalConfiguration"},
+	{"ZoneConfiguration"},
+}
+
+// JSONPatch returns the RFC 6902 JSON Patch turning from into to.  Resources are added and removed whole, and
+// changed resources are patched field by field.  Lists are replaced whole.
+func JSONPatch(from, to *ZoneDefinition, opts DiffOptions) ([]PatchOperation, error) {
+	before, after, err := patchTrees(from, to)
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]PatchOperation, 0)
+	patchObject(nil, before, after, opts.Ignore, func(p []string, op string, value interface{}) {
+		ops = append(ops, PatchOperation{Op: op, Path: jsonPointer(p), Value: value})
+	})
+	return ops, nil
+}
+
+// MergePatch returns the RFC 7396 merge patch turning from into to, computed as JSONPatch is
+func MergePatch(from, to *ZoneDefinition, opts DiffOptions) (map[string]interface{}, error) {
+	before, after, err := patchTrees(from, to)
+	if err != nil {
+		return nil, err
+	}
+	patch := make(map[string]interface{})
+	patchObject(nil, before, after, opts.Ignore, func(p []string, op string, value interface{}) {
+		obj := patch
+		for _, k := range p[:len(p)-1] {
+			next, ok := obj[k].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				obj[k] = next
+			}
+			obj = next
+		}
+		if op == PatchRemove {
+			value = nil
+		}
+		obj[p[len(p)-1]] = value
+	})
+	return patch, nil
+}
+
+// ApplyJSONPatch applies an RFC 6902 JSON Patch to a copy of zd.  Only the add, remove and replace operations on
+// object members are supported.
+func ApplyJSONPatch(zd *ZoneDefinition, ops []PatchOperation) (*ZoneDefinition, error) {
+	if zd == nil {
+		return nil, errors.New("zone definition cannot be empty")
+	}
+	tree, err := definitionTree(zd)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		p, err := parsePointer(op.Path)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %s", i, err)
+		}
+		if len(p) == 0 {
+			return nil, fmt.Errorf("operation %d: cannot %s the whole definition", i, op.Op)
+		}
+		parent, err := pointerParent(tree, p)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %s", i, err)
+		}
+		key := p[len(p)-1]
+		switch op.Op {
+		case PatchAdd:
+			parent[key] = op.Value
+		case PatchRemove, PatchReplace:
+			if _, ok := parent[key]; !ok {
+				return nil, fmt.Errorf("operation %d: path \"%s\" does not exist", i, op.Path)
+			}
+			if op.Op == PatchRemove {
+				delete(parent, key)
+			} else {
+				parent[key] = op.Value
+			}
+		default:
+			return nil, fmt.Errorf("operation %d: unsupported op \"%s\"", i, op.Op)
+		}
+	}
+	return definitionFromTree(tree)
+}
+
+// ApplyMergePatch applies an RFC 7396 merge patch to a copy of zd
+func ApplyMergePatch(zd *ZoneDefinition, patch map[string]interface{}) (*ZoneDefinition, error) {
+	if zd == nil {
+		return nil, errors.New("zone definition cannot be empty")
+	}
+	tree, err := definitionTree(zd)
+	if err != nil {
+		return nil, err
+	}
+	return definitionFromTree(mergePatch(tree, patch).(map[string]interface{}))
+}
+
+func patchTrees(from, to *ZoneDefinition) (map[string]interface{}, map[string]interface{}, error) {
+	if from == nil || to == nil {
+		return nil, nil, errors.New("zone definitions cannot be empty")
+	}
+	before, err := definitionTree(from)
+	if err != nil {
+		return nil, nil, err
+	}
+	after, err := definitionTree(to)
+	if err != nil {
+		return nil, nil, err
+	}
+	return before, after, nil
+}
+
+// patchObject calls emit, in path order, for every member of before or after that must be added, removed or
+// replaced for before to become after
+func patchObject(p []string, before, after map[string]interface{}, ignore []string, emit func([]string, string, interface{})) {
+	keyed := isNameKeyed(p)
+	keys := make(map[string]bool, len(before)+len(after))
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		if keyed || !ignored(k, ignore) {
+			sorted = append(sorted, k)
+		}
+	}
+	sort.Strings(sorted)
+
+	for _, k := range sorted {
+		kp := append(append([]string{}, p...), k)
+		b, inBefore := before[k]
+		a, inAfter := after[k]
+		switch {
+		case !inBefore:
+			emit(kp, PatchAdd, a)
+		case !inAfter:
+			emit(kp, PatchRemove, nil)
+		default:
+			bObj, bOK := b.(map[string]interface{})
+			aObj, aOK := a.(map[string]interface{})
+			if bOK && aOK {
+				patchObject(kp, bObj, aObj, ignore, emit)
+			} else if !reflect.DeepEqual(b, a) {
+				emit(kp, PatchReplace, a)
+			}
+		}
+	}
+}
+
+func isNameKeyed(p []string) bool {
+	for _, keyed := range nameKeyed {
+		if len(keyed) != len(p) {
+			continue
+		}
+		match := true
+		for i := range keyed {
+			if keyed[i] != "*" && keyed[i] != p[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	obj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range obj {
+		if v == nil {
+			delete(t, k)
+		} else {
+			t[k] = mergePatch(t[k], v)
+		}
+	}
+	return t
+}
+
+func definitionFromTree(tree map[string]interface{}) (*ZoneDefinition, error) {
+	b, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJSON(b)
+}
+
+// jsonPointer renders an RFC 6901 JSON Pointer
+func jsonPointer(p []string) string {
+	if len(p) == 0 {
+		return ""
+	}
+	r := strings.NewReplacer("~", "~0", "/", "~1")
+	escaped := make([]string, len(p))
+	for i, k := range p {
+		escaped[i] = r.Replace(k)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path \"%s\" must start with \"/\"", pointer)
+	}
+	r := strings.NewReplacer("~1", "/", "~0", "~")
+	p := strings.Split(pointer[1:], "/")
+	for i, k := range p {
+		p[i] = r.Replace(k)
+	}
+	return p, nil
+}
+
+func pointerParent(tree map[string]interface{}, p []string) (map[string]interface{}, error) {
+	obj := tree
+	for i, k := range p[:len(p)-1] {
+		next, ok := obj[k].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("path \"%s\" is not an object", jsonPointer(p[:i+1]))
+		}
+		obj = next
+	}
+	return obj, nil
+}
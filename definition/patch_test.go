@@ -0,0 +1,116 @@
+package definition
+
+import (
+	"encoding/json"
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"testing"
+)
+
+func patchFixtures() (*ZoneDefinition, *ZoneDefinition) {
+	from := NewZoneDefinition(cloudstack.Zone{Id: "z1", Name: "zone", Dns1: "8.8.8.8"})
+	from.Pods["POD-A"] = cloudstack.Pod{Name: "POD-A", Endip: "10.0.0.200"}
+	from.Pods["POD-B"] = cloudstack.Pod{Name: "POD-B"}
+	from.ComputeOfferings["so"] = cloudstack.ServiceOffering{
+		Name:                   "so",
+		Serviceofferingdetails: map[string]string{"a": "b"},
+	}
+	from.PhysicalNetworks["pn/1"] = PhysicalNetwork{
+		PhysicalNetwork: cloudstack.PhysicalNetwork{Name: "pn/1"},
+		TrafficTypes: map[string]TrafficType{
+			"Guest": {Labels: TrafficTypeLabels{KVM: "cloudbr0"}},
+		},
+	}
+
+	to := NewZoneDefinition(cloudstack.Zone{Id: "z1", Name: "zone", Dns1: "1.1.1.1"})
+	to.Pods["POD-A"] = cloudstack.Pod{Name: "POD-A", Endip: "10.0.0.250"}
+	to.Pods["POD-C"] = cloudstack.Pod{Name: "POD-C"}
+	to.ComputeOfferings["so"] = cloudstack.ServiceOffering{Name: "so"}
+	to.PhysicalNetworks["pn/1"] = PhysicalNetwork{
+		PhysicalNetwork: cloudstack.PhysicalNetwork{Name: "pn/1"},
+		TrafficTypes: map[string]TrafficType{
+			"Guest": {Labels: TrafficTypeLabels{KVM: "br-guest"}},
+		},
+	}
+	return from, to
+}
+
+func TestJSONPatchRoundTrip(t *testing.T) {
+	from, to := patchFixtures()
+
+	ops, err := JSONPatch(from, to, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every add and replace must carry a value member, null or not, and removes none
+	var raw []map[string]interface{}
+	if err = json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	nullReplaced := false
+	for _, op := range raw {
+		value, ok := op["value"]
+		switch op["op"] {
+		case PatchAdd, PatchReplace:
+			if !ok {
+				t.Errorf("%s %s has no value member", op["op"], op["path"])
+			}
+			if op["path"] == "/ComputeOfferings/so/serviceofferingdetails" && value == nil {
+				nullReplaced = true
+			}
+		case PatchRemove:
+			if ok {
+				t.Errorf("remove %s has a value member", op["path"])
+			}
+		default:
+			t.Errorf("unexpected op %v", op["op"])
+		}
+	}
+	if !nullReplaced {
+		t.Errorf("expected serviceofferingdetails to be replaced with null in %s", b)
+	}
+
+	var parsed []PatchOperation
+	if err = json.Unmarshal(b, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	patched, err := ApplyJSONPatch(from, parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs, err := Diff(patched, to, DiffOptions{}); err != nil {
+		t.Fatal(err)
+	} else if len(diffs) > 0 {
+		t.Errorf("patched definition differs from target: %+v", diffs)
+	}
+}
+
+func TestMergePatchRoundTrip(t *testing.T) {
+	from, to := patchFixtures()
+
+	patch, err := MergePatch(from, to, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed map[string]interface{}
+	if err = json.Unmarshal(b, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	patched, err := ApplyMergePatch(from, parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs, err := Diff(patched, to, DiffOptions{}); err != nil {
+		t.Fatal(err)
+	} else if len(diffs) > 0 {
+		t.Errorf("patched definition differs from target: %+v", diffs)
+	}
+}
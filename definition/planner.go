@@ -0,0 +1,139 @@
+package definition
+
+import (
+	"fmt"
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"sort"
+	"strings"
+)
+
+type (
+	// RestoreStep is a single node in a RestorePlan
+	RestoreStep struct {
+		Name     string
+		Requires []string
+		Restorer Restorer
+	}
+
+	// RestorePlan is a graph of restore steps and their dependencies
+	RestorePlan struct {
+		steps map[string]*RestoreStep
+	}
+)
+
+func NewRestorePlan(restorers ...Restorer) *RestorePlan {
+	p := &RestorePlan{steps: make(map[string]*RestoreStep, len(restorers))}
+	for _, r := range restorers {
+		p.Add(r)
+	}
+	return p
+}
+
+// Add places a restorer in the plan, replacing any step of the same name.  Additional dependencies may be
+// provided alongside those the restorer itself requires.
+func (p *RestorePlan) Add(r Restorer, requires ...string) {
+	p.steps[r.Name()] = &RestoreStep{
+		Name:     r.Name(),
+		Requires: append(append([]string{}, r.Requires()...), requires...),
+		Restorer: r,
+	}
+}
+
+// Sort returns the steps of the plan ordered such that every step follows those it requires.  Steps which are
+// otherwise independent of one another are ordered by name.
+func (p *RestorePlan) Sort() ([]*RestoreStep, error) {
+	var ready []string
+
+	remaining := make(map[string]int, len(p.steps))
+	dependents := make(map[string][]string, len(p.steps))
+
+	for name, step := range p.steps {
+		remaining[name] = 0
+		for _, req := range step.Requires {
+			if _, ok := p.steps[req]; !ok {
+				return nil, fmt.Errorf("restore step \"%s\" requires unknown step \"%s\"", name, req)
+			}
+			remaining[name]++
+			dependents[req] = append(dependents[req], name)
+		}
+		if remaining[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	sorted := make([]*RestoreStep, 0, len(p.steps))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, p.steps[name])
+		for _, dep := range dependents[name] {
+			if remaining[dep]--; remaining[dep] == 0 {
+				ready = append(ready, dep)
+			}
+		}
+	}
+
+	if len(sorted) != len(p.steps) {
+		cyclic := make([]string, 0)
+		for name, count := range remaining {
+			if count > 0 {
+				cyclic = append(cyclic, name)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, fmt.Errorf("restore steps contain a dependency cycle: %s", strings.Join(cyclic, ", "))
+	}
+
+	return sorted, nil
+}
+
+// dependents returns the names of the steps which require the named step, directly or through other steps
+func (p *RestorePlan) dependents(name string) []string {
+	found := make(map[string]bool)
+	queue := []string{name}
+	for len(queue) > 0 {
+		next := queue[0]
+		queue = queue[1:]
+		for dep, step := range p.steps {
+			for _, req := range step.Requires {
+				if req == next && !found[dep] {
+					found[dep] = true
+					queue = append(queue, dep)
+				}
+			}
+		}
+	}
+	names := make([]string, 0, len(found))
+	for dep := range found {
+		names = append(names, dep)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run executes each step of the plan in dependency order, stopping at the first error.  Steps the restoration
+// has already completed are skipped.
+func (p *RestorePlan) Run(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	steps, err := p.Sort()
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if rs.completed(step.Name) {
+			log.Printf("Skipping step %s, completed by an earlier run", step.Name)
+			continue
+		}
+		rs.step = step.Name
+		if err = step.Restorer.Restore(client, rs); err != nil {
+			return fmt.Errorf("restore step \"%s\" failed: %s", step.Name, err)
+		}
+		rs.Completed = append(rs.Completed, step.Name)
+		if rs.checkpoint != nil {
+			if err = rs.checkpoint(rs.State()); err != nil {
+				return fmt.Errorf("unable to checkpoint restore after step \"%s\": %s", step.Name, err)
+			}
+		}
+	}
+	return nil
+}
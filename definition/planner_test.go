@@ -0,0 +1,167 @@
+package definition
+
+import (
+	"errors"
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRestorer records the order it is run in, failing when told to
+type testRestorer struct {
+	name     string
+	requires []string
+	ran      *[]string
+	err      error
+}
+
+func (tr *testRestorer) Name() string {
+	return tr.name
+}
+
+func (tr *testRestorer) Requires() []string {
+	return tr.requires
+}
+
+func (tr *testRestorer) Restore(*cloudstack.CloudStackClient, *Restoration) error {
+	*tr.ran = append(*tr.ran, tr.name)
+	return tr.err
+}
+
+func stepNames(steps []*RestoreStep) []string {
+	names := make([]string, len(steps))
+	for i, step := range steps {
+		names[i] = step.Name
+	}
+	return names
+}
+
+func TestRestorePlanSort(t *testing.T) {
+	var ran []string
+	p := NewRestorePlan(
+		&testRestorer{name: "hosts", requires: []string{"clusters"}, ran: &ran},
+		&testRestorer{name: "offerings", ran: &ran},
+		&testRestorer{name: "clusters", requires: []string{"pods"}, ran: &ran},
+		&testRestorer{name: "pods", requires: []string{"zone"}, ran: &ran},
+		&testRestorer{name: "zone", ran: &ran},
+		&testRestorer{name: "enable", requires: []string{"hosts", "pods"}, ran: &ran},
+	)
+	steps, err := p.Sort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// independent steps are ordered by name
+	want := []string{"offerings", "zone", "pods", "clusters", "hosts", "enable"}
+	if got := stepNames(steps); !reflect.DeepEqual(got, want) {
+		t.Errorf("got order %v, want %v", got, want)
+	}
+}
+
+func TestRestorePlanSortDefault(t *testing.T) {
+	steps, err := NewRestorePlan(RegisteredRestorers()...).Sort()
+	if err != nil {
+		t.Fatal(err)
+	}
+	position := make(map[string]int, len(steps))
+	for i, step := range steps {
+		position[step.Name] = i
+	}
+	for _, step := range steps {
+		for _, req := range step.Requires {
+			if position[req] > position[step.Name] {
+				t.Errorf("step %s runs before %s, which it requires", step.Name, req)
+			}
+		}
+	}
+}
+
+func TestRestorePlanSortCycle(t *testing.T) {
+	var ran []string
+	p := NewRestorePlan(
+		&testRestorer{name: "a", requires: []string{"c"}, ran: &ran},
+		&testRestorer{name: "b", requires: []string{"a"}, ran: &ran},
+		&testRestorer{name: "c", requires: []string{"b"}, ran: &ran},
+		&testRestorer{name: "d", ran: &ran},
+		&testRestorer{name: "e", requires: []string{"c"}, ran: &ran},
+	)
+	_, err := p.Sort()
+	if err == nil {
+		t.Fatal("expected a dependency cycle error")
+	}
+	// steps waiting on the cycle are reported along with it
+	if !strings.HasSuffix(err.Error(), "cycle: a, b, c, e") {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestRestorePlanSortUnknown(t *testing.T) {
+	var ran []string
+	p := NewRestorePlan(&testRestorer{name: "pods", requires: []string{"zone"}, ran: &ran})
+	_, err := p.Sort()
+	if err == nil || !strings.Contains(err.Error(), `"pods" requires unknown step "zone"`) {
+		t.Errorf("expected an unknown step error, got %v", err)
+	}
+	if err = p.Run(nil, NewRestoration(NewZoneDefinition(cloudstack.Zone{}))); err == nil {
+		t.Error("expected run to refuse an unsortable plan")
+	}
+	if len(ran) > 0 {
+		t.Errorf("expected nothing to run, ran %v", ran)
+	}
+}
+
+func TestRestorePlanAddCopiesRequires(t *testing.T) {
+	var ran []string
+	shared := make([]string, 1, 4)
+	shared[0] = "zone"
+	r := &testRestorer{name: "pods", requires: shared, ran: &ran}
+
+	p := NewRestorePlan()
+	p.Add(r, "physicalNetworks")
+	q := NewRestorePlan()
+	q.Add(r, "networks")
+
+	if !reflect.DeepEqual(shared, []string{"zone"}) || !reflect.DeepEqual(shared[:2], []string{"zone", ""}) {
+		t.Errorf("restorer requirements were modified: %v", shared[:cap(shared)])
+	}
+	if got := p.steps["pods"].Requires; !reflect.DeepEqual(got, []string{"zone", "physicalNetworks"}) {
+		t.Errorf("got requirements %v", got)
+	}
+	if got := q.steps["pods"].Requires; !reflect.DeepEqual(got, []string{"zone", "networks"}) {
+		t.Errorf("got requirements %v", got)
+	}
+}
+
+func TestRestorePlanRun(t *testing.T) {
+	var ran []string
+	failure := errors.New("boom")
+	p := NewRestorePlan(
+		&testRestorer{name: "zone", ran: &ran},
+		&testRestorer{name: "pods", requires: []string{"zone"}, ran: &ran},
+		&testRestorer{name: "clusters", requires: []string{"pods"}, ran: &ran, err: failure},
+		&testRestorer{name: "hosts", requires: []string{"clusters"}, ran: &ran},
+	)
+
+	rs := NewRestoration(NewZoneDefinition(cloudstack.Zone{}))
+	rs.Completed = append(rs.Completed, "zone")
+	checkpoints := 0
+	rs.checkpoint = func(state *RestoreState) error {
+		checkpoints++
+		return nil
+	}
+
+	err := p.Run(nil, rs)
+	if err == nil || !strings.Contains(err.Error(), `"clusters" failed: boom`) {
+		t.Fatalf("expected clusters to fail, got %v", err)
+	}
+	// completed steps are skipped, and nothing runs after a failure
+	if !reflect.DeepEqual(ran, []string{"pods", "clusters"}) {
+		t.Errorf("ran %v", ran)
+	}
+	if !reflect.DeepEqual(rs.Completed, []string{"zone", "pods"}) {
+		t.Errorf("completed %v", rs.Completed)
+	}
+	if checkpoints != 1 {
+		t.Errorf("expected a checkpoint after pods only, got %d", checkpoints)
+	}
+}
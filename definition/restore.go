@@ -0,0 +1,362 @@
+package definition
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	RestoreStatusCreated  = "created"
+	RestoreStatusExisting = "existing"
+	RestoreStatusUpdated  = "updated"
+	RestoreStatusSkipped  = "skipped"
+	RestoreStatusDeleted  = "deleted"
+
+	PlanActionCreate = "create"
+	PlanActionUpdate = "update"
+	PlanActionSkip   = "skip"
+	PlanActionDelete = "delete"
+
+	// MaskedValue replaces secrets in recorded api parameters
+	MaskedValue = "******"
+)
+
+type (
+	RestoreConfig struct {
+		Key      string `json:"key"`
+		Secret   string `json:"secret"`
+		Scheme   string `json:"scheme"`
+		Host     string `json:"host"`
+		Path     string `json:"path"`
+		ZoneID   string `json:"zoneID"`
+		ZoneName string `json:"zoneName"`
+
+		Inputs  RestoreInputs  `json:"inputs"`
+		Options RestoreOptions `json:"options"`
+		Filter  RestoreFilter  `json:"filter"`
+
+		// Restorers limits the restore to the provided restorers.  When empty every registered restorer is used.
+		Restorers []Restorer `json:"-"`
+
+		// Journal, when set, receives an entry for each resource as it is created.  See ParseJournal.
+		Journal io.Writer `json:"-"`
+
+		// Checkpoint, when set, is called with the state of the restore each time a step completes
+		Checkpoint func(*RestoreState) error `json:"-"`
+		// Resume, when set, continues the restore from a checkpoint, skipping the steps it completed
+		Resume *RestoreState `json:"-"`
+	}
+
+	RestoreOptions struct {
+		// IncludeSystemTemplates causes system and builtin templates to be registered along with user templates
+		IncludeSystemTemplates bool `json:"includeSystemTemplates"`
+		// TemplateTimeout limits how long to wait for registered templates to become ready
+		TemplateTimeout time.Duration `json:"templateTimeout"`
+		// DryRun prevents any mutating api call from being made.  The calls that would have been made are
+		// recorded in the results of the restore instead.
+		DryRun bool `json:"dryRun"`
+		// RollbackOnFailure deletes every resource the restore created when a step fails
+		RollbackOnFailure bool `json:"rollbackOnFailure"`
+	}
+
+	RestoreResult struct {
+		Step     string `json:"step"`
+		Resource string `json:"resource"`
+		Name     string `json:"name"`
+		Status   string `json:"status"`
+		ID       string `json:"id,omitempty"`
+		Message  string `json:"message,omitempty"`
+
+		// Command and Params describe the api call made, if any.  Secrets within Params are masked.
+		Command string            `json:"command,omitempty"`
+		Params  map[string]string `json:"params,omitempty"`
+	}
+
+	// changes describes the fields of an existing resource that differ from the source, as "field: old => new"
+	changes []string
+
+	// Restoration holds the state shared between restorers over the course of a single restore
+	Restoration struct {
+		// ZoneID and ZoneName, when set, point the restore at an existing zone
+		ZoneID   string
+		ZoneName string
+
+		// Source is the definition being restored
+		Source *ZoneDefinition
+		// Target is populated with the resources that exist in, or were created in, the target zone
+		Target *ZoneDefinition
+
+		Inputs  RestoreInputs
+		Options RestoreOptions
+
+		Results []RestoreResult
+		// Journal holds the resources created by the restore that have not since been rolled back
+		Journal []JournalEntry
+		// Completed lists the steps that have finished, including those finished by a resumed restore
+		Completed []string
+
+		journalWriter io.Writer
+		checkpoint    func(*RestoreState) error
+
+		filter  RestoreFilter
+		related *restoreRelated
+
+		step    string
+		domains map[string]string
+		osTypes map[string]string
+	}
+)
+
+func NewRestoration(zd *ZoneDefinition) *Restoration {
+	rs := &Restoration{
+		Source:    zd,
+		Target:    NewZoneDefinition(cloudstack.Zone{}),
+		Results:   make([]RestoreResult, 0),
+		Journal:   make([]JournalEntry, 0),
+		Completed: make([]string, 0),
+		domains:   make(map[string]string),
+		osTypes:   make(map[string]string),
+	}
+	return rs
+}
+
+// PlanAction returns the action a dry run result represents
+func (r RestoreResult) PlanAction() string {
+	switch r.Status {
+	case RestoreStatusCreated:
+		return PlanActionCreate
+	case RestoreStatusUpdated:
+		return PlanActionUpdate
+	case RestoreStatusDeleted:
+		return PlanActionDelete
+	}
+	return PlanActionSkip
+}
+
+func (rs *Restoration) add(res RestoreResult) {
+	res.Step = rs.step
+	rs.Results = append(rs.Results, res)
+	status := res.Status
+	if rs.Options.DryRun && res.Command != "" {
+		status = "planned " + res.PlanAction()
+	}
+	if res.Message == "" {
+		log.Printf("  %s %s: %s", res.Resource, res.Name, status)
+	} else {
+		log.Printf("  %s %s: %s (%s)", res.Resource, res.Name, status, res.Message)
+	}
+}
+
+func (rs *Restoration) record(resource, name, status, id, message string) {
+	rs.add(RestoreResult{
+		Resource: resource,
+		Name:     name,
+		Status:   status,
+		ID:       id,
+		Message:  message,
+	})
+}
+
+// apply makes a mutating api call and records its result, copying the response into out.  In a dry run the call
+// is only recorded, out is left untouched, and a placeholder is returned in place of the id of a new resource.
+func (rs *Restoration) apply(res RestoreResult, params interface{}, call func() (interface{}, error), out interface{}) (string, error) {
+	res.Params = describeParams(params)
+	if rs.Options.DryRun {
+		if res.ID == "" {
+			res.ID = placeholderID(res.Resource, res.Name)
+		}
+		rs.add(res)
+		return res.ID, nil
+	}
+	resp, err := call()
+	if err != nil {
+		return "", err
+	}
+	if res.ID == "" {
+		created := struct {
+			Id string `json:"id"`
+		}{}
+		if convert(resp, &created) == nil {
+			res.ID = created.Id
+		}
+	}
+	rs.add(res)
+	// the resource exists from here on, so it is journaled before anything else can fail
+	if res.Status == RestoreStatusCreated {
+		if err = rs.journal(res); err != nil {
+			return res.ID, err
+		}
+	}
+	if out != nil {
+		if err = convert(resp, out); err != nil {
+			return res.ID, err
+		}
+	}
+	return res.ID, nil
+}
+
+func (c *changes) add(field string, from, to interface{}) {
+	*c = append(*c, fmt.Sprintf("%s: %v => %v", field, from, to))
+}
+
+// converge brings an existing resource in line with the source by applying the update call when any of its fields
+// differ.  A resource which already matches is recorded as existing and nothing is called.
+func (rs *Restoration) converge(res RestoreResult, diff changes, params interface{}, call func() (interface{}, error), out interface{}) error {
+	if len(diff) == 0 {
+		res.Status = RestoreStatusExisting
+		rs.add(res)
+		return nil
+	}
+	res.Status = RestoreStatusUpdated
+	res.Message = strings.Join(diff, ", ")
+	_, err := rs.apply(res, params, call, out)
+	return err
+}
+
+// fetch populates the target definition with the results of a fetcher.  Nothing can be fetched from a zone
+// that so far only exists in a dry run, or that a filtered restore has left alone.
+func (rs *Restoration) fetch(client *cloudstack.CloudStackClient, f Fetcher) error {
+	if rs.Target.Zone.Id == "" || isPlaceholderID(rs.Target.Zone.Id) {
+		return nil
+	}
+	return f.Fetch(client, rs.Target)
+}
+
+func placeholderID(resource, name string) string {
+	return fmt.Sprintf("<new %s %s>", resource, name)
+}
+
+func isPlaceholderID(id string) bool {
+	return strings.HasPrefix(id, "<new ")
+}
+
+// domainID locates the domain in the target with the provided name, as domain ids from the source are
+// meaningless there
+func (rs *Restoration) domainID(client *cloudstack.CloudStackClient, name string) (string, error) {
+	if id, ok := rs.domains[name]; ok {
+		return id, nil
+	}
+	id, _, err := client.Domain.GetDomainID(name, func(_ *cloudstack.CloudStackClient, p interface{}) error {
+		p.(*cloudstack.ListDomainsParams).SetListall(true)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to locate domain %s: %s", name, err)
+	}
+	rs.domains[name] = id
+	return id, nil
+}
+
+// osTypeID locates the os type in the target with the provided description
+func (rs *Restoration) osTypeID(client *cloudstack.CloudStackClient, description string) (string, error) {
+	if id, ok := rs.osTypes[description]; ok {
+		return id, nil
+	}
+	params := client.GuestOS.NewListOsTypesParams()
+	params.SetDescription(description)
+	osTypes, err := client.GuestOS.ListOsTypes(params)
+	if err != nil {
+		return "", err
+	}
+	for _, osType := range osTypes.OsTypes {
+		if osType.Description == description {
+			rs.osTypes[description] = osType.Id
+			return osType.Id, nil
+		}
+	}
+	return "", fmt.Errorf("unable to locate os type %s", description)
+}
+
+// splitList splits a comma separated list as returned by CloudStack, an empty string being an empty list
+func splitList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+// convert copies the values of one CloudStack response type into another with matching json tags
+func convert(from, to interface{}) error {
+	b, err := json.Marshal(from)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, to)
+}
+
+// RestoreDefinition applies the provided definition to the configured management server.  The returned
+// Restoration is populated with whatever was done, even if an error is also returned.
+func RestoreDefinition(conf RestoreConfig, zd *ZoneDefinition) (*Restoration, error) {
+	if zd == nil {
+		return nil, errors.New("zone definition cannot be empty")
+	}
+	if conf.ZoneID != "" && conf.ZoneName != "" {
+		return nil, errors.New("zone id and zone name are mutually exclusive")
+	}
+
+	var plan *RestorePlan
+
+	if len(conf.Restorers) == 0 {
+		plan = NewRestorePlan(RegisteredRestorers()...)
+	} else {
+		plan = NewRestorePlan(conf.Restorers...)
+	}
+
+	// catch dependency and filter problems before anything is touched
+	if _, err := plan.Sort(); err != nil {
+		return nil, err
+	}
+	if err := conf.Filter.validate(plan); err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(conf.Key, conf.Secret, conf.Scheme, conf.Host, conf.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	rs := NewRestoration(zd)
+	rs.ZoneID = conf.ZoneID
+	rs.ZoneName = conf.ZoneName
+	rs.Inputs = conf.Inputs
+	rs.Options = conf.Options
+	rs.journalWriter = conf.Journal
+	rs.filter = conf.Filter
+	rs.related = conf.Filter.related(zd)
+	if !rs.Options.DryRun {
+		rs.checkpoint = conf.Checkpoint
+	}
+	if conf.Resume != nil {
+		if err = rs.resume(conf.Resume); err != nil {
+			return nil, err
+		}
+		if err = rs.checkResumedZone(client); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = plan.Run(client, rs); err != nil {
+		if rs.Options.RollbackOnFailure && !rs.Options.DryRun && len(rs.Journal) > 0 {
+			log.Printf("Restore failed, rolling back %d resource(s)...", len(rs.Journal))
+			entries := append([]JournalEntry{}, rs.Journal...)
+			if rerr := rs.rollback(client); rerr != nil {
+				return rs, fmt.Errorf("%s; rollback failed: %s", err, rerr)
+			}
+			// the checkpoint must no longer claim what was just deleted, nor anything that relied on it
+			rs.reopen(plan, entries)
+			if rs.checkpoint != nil {
+				if cerr := rs.checkpoint(rs.State()); cerr != nil {
+					return rs, fmt.Errorf("%s; unable to checkpoint restore after rollback: %s", err, cerr)
+				}
+			}
+		}
+		return rs, err
+	}
+
+	return rs, nil
+}
@@ -0,0 +1,352 @@
+package definition
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeCloudStack serves just enough of the CloudStack api for a restore to run against.  List calls without a
+// handler list nothing, and any other call without one fails.
+type fakeCloudStack struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	handlers map[string]func(url.Values) (interface{}, error)
+	calls    []string
+	nextID   int
+
+	zones  []*cloudstack.Zone
+	stores []*cloudstack.ImageStore
+}
+
+func newFakeCloudStack() *fakeCloudStack {
+	f := &fakeCloudStack{handlers: make(map[string]func(url.Values) (interface{}, error))}
+	f.Server = httptest.NewServer(f)
+
+	f.handle("listZones", func(q url.Values) (interface{}, error) {
+		zones := make([]*cloudstack.Zone, 0)
+		for _, zone := range f.zones {
+			if (q.Get("id") == "" || q.Get("id") == zone.Id) && (q.Get("name") == "" || q.Get("name") == zone.Name) {
+				zones = append(zones, zone)
+			}
+		}
+		return cloudstack.ListZonesResponse{Count: len(zones), Zones: zones}, nil
+	})
+	f.handle("createZone", func(q url.Values) (interface{}, error) {
+		zone := &cloudstack.Zone{
+			Id:              f.id("zone"),
+			Name:            q.Get("name"),
+			Dns1:            q.Get("dns1"),
+			Internaldns1:    q.Get("internaldns1"),
+			Networktype:     q.Get("networktype"),
+			Allocationstate: q.Get("allocationstate"),
+		}
+		f.zones = append(f.zones, zone)
+		return zone, nil
+	})
+	f.handle("updateZone", func(q url.Values) (interface{}, error) {
+		for _, zone := range f.zones {
+			if zone.Id == q.Get("id") {
+				if state := q.Get("allocationstate"); state != "" {
+					zone.Allocationstate = state
+				}
+				return zone, nil
+			}
+		}
+		return nil, fmt.Errorf("no zone %s", q.Get("id"))
+	})
+	f.handle("deleteZone", func(q url.Values) (interface{}, error) {
+		for i, zone := range f.zones {
+			if zone.Id == q.Get("id") {
+				f.zones = append(f.zones[:i], f.zones[i+1:]...)
+				return cloudstack.DeleteZoneResponse{Success: "true"}, nil
+			}
+		}
+		return nil, fmt.Errorf("no zone %s", q.Get("id"))
+	})
+
+	f.handle("listImageStores", func(q url.Values) (interface{}, error) {
+		stores := make([]*cloudstack.ImageStore, 0)
+		for _, store := range f.stores {
+			if q.Get("zoneid") == "" || q.Get("zoneid") == store.Zoneid {
+				stores = append(stores, store)
+			}
+		}
+		return cloudstack.ListImageStoresResponse{Count: len(stores), ImageStores: stores}, nil
+	})
+	f.handle("addImageStore", func(q url.Values) (interface{}, error) {
+		store := &cloudstack.ImageStore{
+			Id:           f.id("store"),
+			Name:         q.Get("name"),
+			Providername: q.Get("provider"),
+			Url:          q.Get("url"),
+			Zoneid:       q.Get("zoneid"),
+			Scope:        "ZONE",
+		}
+		if store.Zoneid == "" {
+			store.Scope = StorageScopeRegion
+		}
+		f.stores = append(f.stores, store)
+		return store, nil
+	})
+	return f
+}
+
+func (f *fakeCloudStack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	q := r.URL.Query()
+	command := q.Get("command")
+	f.calls = append(f.calls, command)
+
+	var resp interface{} = struct{}{}
+	var err error
+	if h, ok := f.handlers[command]; ok {
+		resp, err = h(q)
+	} else if !strings.HasPrefix(command, "list") {
+		err = fmt.Errorf("unexpected command %s", command)
+	}
+	if err != nil {
+		w.WriteHeader(431)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"errorresponse": map[string]interface{}{"errorcode": 431, "errortext": err.Error()},
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{strings.ToLower(command) + "response": resp})
+}
+
+func (f *fakeCloudStack) handle(command string, h func(url.Values) (interface{}, error)) {
+	f.handlers[command] = h
+}
+
+func (f *fakeCloudStack) id(resource string) string {
+	f.nextID++
+	return fmt.Sprintf("%s-%d", resource, f.nextID)
+}
+
+// count returns the number of times a command has been called
+func (f *fakeCloudStack) count(command string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, c := range f.calls {
+		if c == command {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeCloudStack) config() RestoreConfig {
+	return RestoreConfig{
+		Key:    "key",
+		Secret: "secret",
+		Scheme: "http",
+		Host:   strings.TrimPrefix(f.URL, "http://"),
+		Path:   "/client/api",
+	}
+}
+
+func testSourceZone() *ZoneDefinition {
+	return NewZoneDefinition(cloudstack.Zone{
+		Id:              "source-zone",
+		Name:            "source",
+		Dns1:            "8.8.8.8",
+		Internaldns1:    "10.0.0.1",
+		Networktype:     "Advanced",
+		Allocationstate: AllocationStateEnabled,
+	})
+}
+
+// result returns the result recorded for the named resource by the most recent run
+func result(t *testing.T, rs *Restoration, resource, name string) RestoreResult {
+	for i := len(rs.Results) - 1; i >= 0; i-- {
+		if rs.Results[i].Resource == resource && rs.Results[i].Name == name {
+			return rs.Results[i]
+		}
+	}
+	t.Fatalf("no result for %s %s", resource, name)
+	return RestoreResult{}
+}
+
+func TestRestoreRegionImageStoreRerun(t *testing.T) {
+	f := newFakeCloudStack()
+	defer f.Close()
+
+	zd := testSourceZone()
+	zd.SecondaryStoragePools["s3-images"] = cloudstack.ImageStore{
+		Id:           "source-store",
+		Name:         "s3-images",
+		Providername: ImageStoreProviderS3,
+		Scope:        StorageScopeRegion,
+	}
+	zd.SecondaryStoragePools["nfs-images"] = cloudstack.ImageStore{
+		Id:           "source-nfs",
+		Name:         "nfs-images",
+		Providername: ImageStoreProviderNFS,
+		Scope:        "ZONE",
+		Url:          "nfs://10.0.0.5/images",
+		Zoneid:       "source-zone",
+	}
+
+	conf := f.config()
+	conf.ZoneName = "clone"
+	conf.Restorers = []Restorer{new(RestoreZone), new(RestoreSecondaryStoragePools)}
+	conf.Inputs.ImageStoreSecrets = ImageStoreSecrets{"s3-images": {"accesskey": "AKIA", "secretkey": "secret"}}
+
+	rs, err := RestoreDefinition(conf, zd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"s3-images", "nfs-images"} {
+		if res := result(t, rs, "Secondary Storage Pool", name); res.Status != RestoreStatusCreated {
+			t.Fatalf("first run: %s is %s, expected it to be created", name, res.Status)
+		}
+	}
+	for _, store := range f.stores {
+		if store.Name == "s3-images" && store.Zoneid != "" {
+			t.Errorf("region wide store was added to zone %s", store.Zoneid)
+		}
+	}
+
+	rs, err = RestoreDefinition(conf, zd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"s3-images", "nfs-images"} {
+		if res := result(t, rs, "Secondary Storage Pool", name); res.Status != RestoreStatusExisting {
+			t.Errorf("second run: %s is %s, expected it to be existing", name, res.Status)
+		}
+	}
+	if n := f.count("addImageStore"); n != 2 {
+		t.Errorf("expected 2 stores to be added over both runs, %d were", n)
+	}
+}
+
+// failingRestorer fails until told otherwise, standing in for any step which fails late in a restore
+type failingRestorer struct {
+	fail bool
+}
+
+func (*failingRestorer) Name() string {
+	return "failing"
+}
+
+func (*failingRestorer) Requires() []string {
+	return []string{"templates"}
+}
+
+func (fr *failingRestorer) Restore(*cloudstack.CloudStackClient, *Restoration) error {
+	if fr.fail {
+		return fmt.Errorf("failed as told")
+	}
+	return nil
+}
+
+func TestRestoreResumeAfterRollback(t *testing.T) {
+	f := newFakeCloudStack()
+	defer f.Close()
+
+	zoneConfig := make(map[string]string)
+	f.handle("listConfigurations", func(q url.Values) (interface{}, error) {
+		configs := make([]*cloudstack.Configuration, 0)
+		if q.Get("zoneid") != "" {
+			value, ok := zoneConfig[q.Get("zoneid")]
+			if !ok {
+				value = "firstfit"
+			}
+			configs = append(configs, &cloudstack.Configuration{Name: "vm.allocation.algorithm", Value: value})
+		}
+		return cloudstack.ListConfigurationsResponse{Count: len(configs), Configurations: configs}, nil
+	})
+	f.handle("updateConfiguration", func(q url.Values) (interface{}, error) {
+		zoneConfig[q.Get("zoneid")] = q.Get("value")
+		return cloudstack.Configuration{Name: q.Get("name"), Value: q.Get("value")}, nil
+	})
+
+	zd := testSourceZone()
+	zd.ZoneConfiguration["vm.allocation.algorithm"] = cloudstack.Configuration{Name: "vm.allocation.algorithm", Value: "random"}
+
+	failing := &failingRestorer{fail: true}
+	var checkpoint []byte
+	conf := f.config()
+	conf.ZoneName = "clone"
+	conf.Restorers = append(RegisteredRestorers(), failing)
+	conf.Options.RollbackOnFailure = true
+	conf.Checkpoint = func(state *RestoreState) error {
+		var err error
+		checkpoint, err = json.Marshal(state)
+		return err
+	}
+
+	if _, err := RestoreDefinition(conf, zd); err == nil || !strings.Contains(err.Error(), "failed as told") {
+		t.Fatalf("expected the restore to fail, got %v", err)
+	}
+	if len(f.zones) != 0 {
+		t.Fatalf("expected the zone to be rolled back, %d remain", len(f.zones))
+	}
+	for _, command := range []string{"updateConfiguration", "updateZone"} {
+		if n := f.count(command); n != 1 {
+			t.Fatalf("first run: expected 1 %s call, got %d", command, n)
+		}
+	}
+
+	state, err := ParseRestoreState(checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(map[string]bool)
+	for _, name := range state.Completed {
+		done[name] = true
+	}
+	// what belongs to the deleted zone must run again, what is independent of it need not
+	for _, name := range []string{"zone", "physicalNetworks", "pods", "secondaryStoragePools", "zoneConfigs", "enableZone", "templates"} {
+		if done[name] {
+			t.Errorf("step %s still completed after its zone was rolled back", name)
+		}
+	}
+	for _, name := range []string{"computeOfferings", "diskOfferings", "globalConfigs"} {
+		if !done[name] {
+			t.Errorf("step %s is no longer completed", name)
+		}
+	}
+	if state.Target.Zone.Id != "" || len(state.Target.ZoneConfiguration) != 0 {
+		t.Errorf("checkpoint still holds the rolled back zone %s and its configuration %v", state.Target.Zone.Id, state.Target.ZoneConfiguration)
+	}
+
+	// resumed as the command line would, without repeating the zone name
+	failing.fail = false
+	conf.ZoneName = ""
+	conf.Resume = state
+	rs, err := RestoreDefinition(conf, zd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.zones) != 1 || f.zones[0].Name != "clone" {
+		t.Fatalf("expected the zone to be created again as clone, got %+v", f.zones)
+	}
+	zone := f.zones[0]
+	if zone.Allocationstate != AllocationStateEnabled {
+		t.Errorf("resumed restore did not enable zone %s", zone.Id)
+	}
+	if zoneConfig[zone.Id] != "random" {
+		t.Errorf("resumed restore did not configure zone %s", zone.Id)
+	}
+	for _, command := range []string{"updateConfiguration", "updateZone"} {
+		if n := f.count(command); n != 2 {
+			t.Errorf("expected %s to be called again, got %d calls", command, n)
+		}
+	}
+	if got := rs.Target.ZoneConfiguration["vm.allocation.algorithm"].Value; got != "random" {
+		t.Errorf("target zone configuration is %q", got)
+	}
+}
@@ -0,0 +1,1677 @@
+package definition
+
+import (
+	"fmt"
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AllocationStateEnabled  = "Enabled"
+	AllocationStateDisabled = "Disabled"
+
+	HostTypeRouting = "Routing"
+
+	StorageScopeZone    = "ZONE"
+	StorageScopeCluster = "CLUSTER"
+	StorageScopeHost    = "HOST"
+	StorageScopeRegion  = "REGION"
+
+	ImageStoreProviderNFS   = "NFS"
+	ImageStoreProviderS3    = "S3"
+	ImageStoreProviderSwift = "Swift"
+
+	PhysicalNetworkStateEnabled = "Enabled"
+
+	TemplateTypeUser    = "USER"
+	TemplateTypeSystem  = "SYSTEM"
+	TemplateTypeBuiltin = "BUILTIN"
+	TemplateTypeRouting = "ROUTING"
+
+	DefaultTemplateTimeout = time.Hour
+)
+
+var (
+	registeredRestorers   map[string]Restorer
+	registeredRestorersMu sync.Mutex
+
+	defaultRestorers []Restorer
+)
+
+func init() {
+	defaultRestorers = []Restorer{
+		new(RestoreZone),
+		new(RestorePhysicalNetworks),
+		new(RestorePods),
+		new(RestoreClusters),
+		new(RestoreHosts),
+		new(RestorePrimaryStoragePools),
+		new(RestoreSecondaryStoragePools),
+		new(RestoreSecondaryStagingStores),
+		new(RestoreComputeOfferings),
+		new(RestoreDiskOfferings),
+		new(RestoreZoneConfigurations),
+		new(RestoreGlobalConfigurations),
+		new(EnableZone),
+		new(RestoreTemplates),
+	}
+	registeredRestorers = make(map[string]Restorer, len(defaultRestorers))
+	for _, dr := range defaultRestorers {
+		registeredRestorers[dr.Name()] = dr
+	}
+}
+
+func DefaultRestorers() []string {
+	restorers := make([]string, len(defaultRestorers))
+	for i, restorer := range defaultRestorers {
+		restorers[i] = restorer.Name()
+	}
+	return restorers
+}
+
+func RegisterRestorer(r Restorer) {
+	registeredRestorersMu.Lock()
+	registeredRestorers[r.Name()] = r
+	registeredRestorersMu.Unlock()
+}
+
+func GetRestorer(name string) (Restorer, bool) {
+	registeredRestorersMu.Lock()
+	r, ok := registeredRestorers[name]
+	registeredRestorersMu.Unlock()
+	return r, ok
+}
+
+// RegisteredRestorers returns every registered restorer, including those registered alongside custom fetchers
+func RegisteredRestorers() []Restorer {
+	registeredRestorersMu.Lock()
+	restorers := make([]Restorer, 0, len(registeredRestorers))
+	for _, r := range registeredRestorers {
+		restorers = append(restorers, r)
+	}
+	registeredRestorersMu.Unlock()
+	return restorers
+}
+
+type Restorer interface {
+	Name() string
+	// Requires returns the names of the restorers which must complete before this one may run
+	Requires() []string
+	Restore(*cloudstack.CloudStackClient, *Restoration) error
+}
+
+type RestoreZone struct{}
+
+func (*RestoreZone) Name() string {
+	return "zone"
+}
+
+func (*RestoreZone) Requires() []string {
+	return nil
+}
+
+// update converges the dns, network and storage settings of an existing zone with those of the source.  The
+// allocation state is left to EnableZone.
+func (*RestoreZone) update(client *cloudstack.CloudStackClient, rs *Restoration, zone cloudstack.Zone) error {
+	var diff changes
+
+	src := rs.Source.Zone
+	params := client.Zone.NewUpdateZoneParams(zone.Id)
+	if src.Dns1 != zone.Dns1 {
+		params.SetDns1(src.Dns1)
+		diff.add("dns1", zone.Dns1, src.Dns1)
+	}
+	if src.Dns2 != zone.Dns2 {
+		params.SetDns2(src.Dns2)
+		diff.add("dns2", zone.Dns2, src.Dns2)
+	}
+	if src.Internaldns1 != zone.Internaldns1 {
+		params.SetInternaldns1(src.Internaldns1)
+		diff.add("internaldns1", zone.Internaldns1, src.Internaldns1)
+	}
+	if src.Internaldns2 != zone.Internaldns2 {
+		params.SetInternaldns2(src.Internaldns2)
+		diff.add("internaldns2", zone.Internaldns2, src.Internaldns2)
+	}
+	if src.Ip6dns1 != zone.Ip6dns1 {
+		params.SetIp6dns1(src.Ip6dns1)
+		diff.add("ip6dns1", zone.Ip6dns1, src.Ip6dns1)
+	}
+	if src.Ip6dns2 != zone.Ip6dns2 {
+		params.SetIp6dns2(src.Ip6dns2)
+		diff.add("ip6dns2", zone.Ip6dns2, src.Ip6dns2)
+	}
+	if src.Guestcidraddress != "" && src.Guestcidraddress != zone.Guestcidraddress {
+		params.SetGuestcidraddress(src.Guestcidraddress)
+		diff.add("guestcidraddress", zone.Guestcidraddress, src.Guestcidraddress)
+	}
+	if src.Domain != "" && src.Domain != zone.Domain {
+		params.SetDomain(src.Domain)
+		diff.add("domain", zone.Domain, src.Domain)
+	}
+	if src.Localstorageenabled != zone.Localstorageenabled {
+		params.SetLocalstorageenabled(src.Localstorageenabled)
+		diff.add("localstorageenabled", zone.Localstorageenabled, src.Localstorageenabled)
+	}
+
+	rs.Target.Zone = zone
+	return rs.converge(
+		RestoreResult{Resource: "Zone", Name: zone.Name, ID: zone.Id, Command: "updateZone"},
+		diff,
+		params,
+		func() (interface{}, error) { return client.Zone.UpdateZone(params) },
+		&rs.Target.Zone)
+}
+
+func (rz *RestoreZone) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	var zone *cloudstack.Zone
+	var count int
+	var err error
+
+	if rs.ZoneID != "" {
+		log.Println("Attempting to fetch target zone " + rs.ZoneID)
+		if zone, _, err = client.Zone.GetZoneByID(rs.ZoneID); err != nil {
+			return err
+		}
+		if !rs.Selected(rs.Source.Zone.Name) {
+			rs.Target.Zone = *zone
+			rs.record("Zone", zone.Name, RestoreStatusExisting, zone.Id, "")
+			return nil
+		}
+		return rz.update(client, rs, *zone)
+	}
+
+	name := rs.ZoneName
+	if name == "" {
+		name = rs.Source.Zone.Name
+	}
+
+	log.Println("Attempting to fetch target zone " + name)
+	zone, count, err = client.Zone.GetZoneByName(name)
+	if err == nil {
+		if !rs.Selected(rs.Source.Zone.Name) {
+			rs.Target.Zone = *zone
+			rs.record("Zone", zone.Name, RestoreStatusExisting, zone.Id, "")
+			return nil
+		}
+		return rz.update(client, rs, *zone)
+	} else if count != 0 {
+		return err
+	}
+
+	if !rs.Selected(rs.Source.Zone.Name) {
+		// steps restoring resources outside of any zone, such as offerings, may still go ahead
+		rs.record("Zone", name, RestoreStatusSkipped, "", "not present in target and excluded by filter")
+		return nil
+	}
+
+	log.Println("Creating Zone " + name + "...")
+	src := rs.Source.Zone
+	params := client.Zone.NewCreateZoneParams(src.Dns1, src.Internaldns1, name, src.Networktype)
+	if src.Dns2 != "" {
+		params.SetDns2(src.Dns2)
+	}
+	if src.Internaldns2 != "" {
+		params.SetInternaldns2(src.Internaldns2)
+	}
+	if src.Ip6dns1 != "" {
+		params.SetIp6dns1(src.Ip6dns1)
+	}
+	if src.Ip6dns2 != "" {
+		params.SetIp6dns2(src.Ip6dns2)
+	}
+	if src.Guestcidraddress != "" {
+		params.SetGuestcidraddress(src.Guestcidraddress)
+	}
+	if src.Domain != "" {
+		params.SetDomain(src.Domain)
+	}
+	params.SetLocalstorageenabled(src.Localstorageenabled)
+	params.SetSecuritygroupenabled(src.Securitygroupsenabled)
+	// the zone is kept disabled until everything beneath it has been restored
+	params.SetAllocationstate(AllocationStateDisabled)
+
+	created := src
+	created.Name = name
+	created.Allocationstate = AllocationStateDisabled
+	id, err := rs.apply(
+		RestoreResult{Resource: "Zone", Name: name, Status: RestoreStatusCreated, Command: "createZone"},
+		params,
+		func() (interface{}, error) { return client.Zone.CreateZone(params) },
+		&created)
+	if err != nil {
+		return err
+	}
+	created.Id = id
+	rs.Target.Zone = created
+	return nil
+}
+
+type RestorePods struct{}
+
+func (*RestorePods) Name() string {
+	return "pods"
+}
+
+func (*RestorePods) Requires() []string {
+	return []string{"zone", "physicalNetworks"}
+}
+
+// update converges the address range and allocation state of an existing pod with those of the source
+func (*RestorePods) update(client *cloudstack.CloudStackClient, rs *Restoration, pod, existing cloudstack.Pod) error {
+	var diff changes
+
+	params := client.Pod.NewUpdatePodParams(existing.Id)
+	if pod.Gateway != existing.Gateway {
+		params.SetGateway(pod.Gateway)
+		diff.add("gateway", existing.Gateway, pod.Gateway)
+	}
+	if pod.Netmask != existing.Netmask {
+		params.SetNetmask(pod.Netmask)
+		diff.add("netmask", existing.Netmask, pod.Netmask)
+	}
+	if pod.Startip != existing.Startip {
+		params.SetStartip(pod.Startip)
+		diff.add("startip", existing.Startip, pod.Startip)
+	}
+	if pod.Endip != "" && pod.Endip != existing.Endip {
+		params.SetEndip(pod.Endip)
+		diff.add("endip", existing.Endip, pod.Endip)
+	}
+	if pod.Allocationstate != "" && pod.Allocationstate != existing.Allocationstate {
+		params.SetAllocationstate(pod.Allocationstate)
+		diff.add("allocationstate", existing.Allocationstate, pod.Allocationstate)
+	}
+
+	updated := existing
+	if err := rs.converge(
+		RestoreResult{Resource: "Pod", Name: pod.Name, ID: existing.Id, Command: "updatePod"},
+		diff,
+		params,
+		func() (interface{}, error) { return client.Pod.UpdatePod(params) },
+		&updated); err != nil {
+		return err
+	}
+	rs.Target.Pods[pod.Name] = updated
+	return nil
+}
+
+func (rp *RestorePods) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Pods...")
+	if err := rs.fetch(client, new(FetchPods)); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(rs.Source.Pods))
+	for name := range rs.Source.Pods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !rs.Selected(name) {
+			continue
+		}
+		pod := rs.Source.Pods[name]
+		if existing, ok := rs.Target.Pods[name]; ok {
+			if err := rp.update(client, rs, pod, existing); err != nil {
+				return err
+			}
+			continue
+		}
+		params := client.Pod.NewCreatePodParams(pod.Gateway, pod.Name, pod.Netmask, pod.Startip, rs.Target.Zone.Id)
+		if pod.Endip != "" {
+			params.SetEndip(pod.Endip)
+		}
+		if pod.Allocationstate != "" {
+			params.SetAllocationstate(pod.Allocationstate)
+		}
+		created := pod
+		id, err := rs.apply(
+			RestoreResult{Resource: "Pod", Name: name, Status: RestoreStatusCreated, Command: "createPod"},
+			params,
+			func() (interface{}, error) { return client.Pod.CreatePod(params) },
+			&created)
+		if err != nil {
+			return err
+		}
+		created.Id = id
+		rs.Target.Pods[name] = created
+	}
+	return nil
+}
+
+type RestoreClusters struct{}
+
+func (*RestoreClusters) Name() string {
+	return "clusters"
+}
+
+func (*RestoreClusters) Requires() []string {
+	return []string{"pods"}
+}
+
+// update converges the allocation state of an existing cluster with that of the source
+func (*RestoreClusters) update(client *cloudstack.CloudStackClient, rs *Restoration, cluster, existing cloudstack.Cluster) error {
+	var diff changes
+
+	params := client.Cluster.NewUpdateClusterParams(existing.Id)
+	if cluster.Allocationstate != "" && cluster.Allocationstate != existing.Allocationstate {
+		params.SetAllocationstate(cluster.Allocationstate)
+		diff.add("allocationstate", existing.Allocationstate, cluster.Allocationstate)
+	}
+
+	updated := existing
+	if err := rs.converge(
+		RestoreResult{Resource: "Cluster", Name: cluster.Name, ID: existing.Id, Command: "updateCluster"},
+		diff,
+		params,
+		func() (interface{}, error) { return client.Cluster.UpdateCluster(params) },
+		&updated); err != nil {
+		return err
+	}
+	rs.Target.Clusters[cluster.Name] = updated
+	return nil
+}
+
+func (rc *RestoreClusters) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Clusters...")
+	if err := rs.fetch(client, new(FetchClusters)); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(rs.Source.Clusters))
+	for name := range rs.Source.Clusters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !rs.Selected(name) {
+			continue
+		}
+		cluster := rs.Source.Clusters[name]
+		if existing, ok := rs.Target.Clusters[name]; ok {
+			if err := rc.update(client, rs, cluster, existing); err != nil {
+				return err
+			}
+			continue
+		}
+		// pod ids from the source zone are meaningless here, so the parent is located by name
+		pod, ok := rs.Target.Pods[cluster.Podname]
+		if !ok {
+			rs.record("Cluster", name, RestoreStatusSkipped, "", "pod "+cluster.Podname+" not present in target zone")
+			continue
+		}
+		extra, ok := rs.Inputs.ClusterParameters.For(cluster)
+		if !ok && cluster.Hypervisortype == "VMware" {
+			rs.record("Cluster", name, RestoreStatusSkipped, "", "no cluster parameters defined for VMware cluster")
+			continue
+		}
+		params := client.Cluster.NewAddClusterParams(cluster.Name, cluster.Clustertype, cluster.Hypervisortype, pod.Id, rs.Target.Zone.Id)
+		if cluster.Allocationstate != "" {
+			params.SetAllocationstate(cluster.Allocationstate)
+		}
+		if cluster.Ovm3vip != "" {
+			params.SetOvm3vip(cluster.Ovm3vip)
+		}
+		if extra.URL != "" {
+			params.SetUrl(extra.URL)
+		}
+		if extra.Username != "" {
+			params.SetUsername(extra.Username)
+		}
+		if extra.Password != "" {
+			params.SetPassword(extra.Password)
+		}
+		if extra.GuestVSwitchName != "" {
+			params.SetGuestvswitchname(extra.GuestVSwitchName)
+		}
+		if extra.GuestVSwitchType != "" {
+			params.SetGuestvswitchtype(extra.GuestVSwitchType)
+		}
+		if extra.PublicVSwitchName != "" {
+			params.SetPublicvswitchname(extra.PublicVSwitchName)
+		}
+		if extra.PublicVSwitchType != "" {
+			params.SetPublicvswitchtype(extra.PublicVSwitchType)
+		}
+		if extra.VSMIPAddress != "" {
+			params.SetVsmipaddress(extra.VSMIPAddress)
+		}
+		if extra.VSMUsername != "" {
+			params.SetVsmusername(extra.VSMUsername)
+		}
+		if extra.VSMPassword != "" {
+			params.SetVsmpassword(extra.VSMPassword)
+		}
+		if extra.OVM3Pool != "" {
+			params.SetOvm3pool(extra.OVM3Pool)
+		}
+		if extra.OVM3Cluster != "" {
+			params.SetOvm3cluster(extra.OVM3Cluster)
+		}
+		created := cluster
+		id, err := rs.apply(
+			RestoreResult{Resource: "Cluster", Name: name, Status: RestoreStatusCreated, Command: "addCluster"},
+			params,
+			func() (interface{}, error) { return client.Cluster.AddCluster(params) },
+			&created)
+		if err != nil {
+			return err
+		}
+		created.Id = id
+		rs.Target.Clusters[name] = created
+	}
+	return nil
+}
+
+type RestoreHosts struct{}
+
+func (*RestoreHosts) Name() string {
+	return "hosts"
+}
+
+// Requires includes physicalNetworks as hosts cannot be added until their traffic types and labels exist
+func (*RestoreHosts) Requires() []string {
+	return []string{"clusters", "physicalNetworks"}
+}
+
+// update converges the tags of an existing host with those of the source
+func (*RestoreHosts) update(client *cloudstack.CloudStackClient, rs *Restoration, host, existing cloudstack.Host) error {
+	var diff changes
+
+	params := client.Host.NewUpdateHostParams(existing.Id)
+	if host.Hosttags != existing.Hosttags {
+		params.SetHosttags(splitList(host.Hosttags))
+		diff.add("hosttags", existing.Hosttags, host.Hosttags)
+	}
+
+	updated := existing
+	if err := rs.converge(
+		RestoreResult{Resource: "Host", Name: host.Name, ID: existing.Id, Command: "updateHost"},
+		diff,
+		params,
+		func() (interface{}, error) { return client.Host.UpdateHost(params) },
+		&updated); err != nil {
+		return err
+	}
+	rs.Target.Hosts[host.Name] = updated
+	return nil
+}
+
+func (rh *RestoreHosts) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Hosts...")
+	if err := rs.fetch(client, new(FetchHosts)); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(rs.Source.Hosts))
+	for name, host := range rs.Source.Hosts {
+		// system vms and storage are brought up by CloudStack itself, only hypervisors are added
+		if host.Type == HostTypeRouting {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !rs.Selected(name) {
+			continue
+		}
+		host := rs.Source.Hosts[name]
+		if existing, ok := rs.Target.Hosts[name]; ok {
+			if err := rh.update(client, rs, host, existing); err != nil {
+				return err
+			}
+			continue
+		}
+		cluster, ok := rs.Target.Clusters[host.Clustername]
+		if !ok {
+			rs.record("Host", name, RestoreStatusSkipped, "", "cluster "+host.Clustername+" not present in target zone")
+			continue
+		}
+		if host.Hypervisor == "VMware" {
+			rs.record("Host", name, RestoreStatusSkipped, "", "VMware hosts are discovered through their cluster")
+			continue
+		}
+		creds, ok := rs.Inputs.HostCredentials.For(host)
+		if !ok {
+			rs.record("Host", name, RestoreStatusSkipped, "", "no credentials defined")
+			continue
+		}
+		params := client.Host.NewAddHostParams(
+			host.Hypervisor,
+			creds.Password,
+			rs.Target.Pods[cluster.Podname].Id,
+			"http://"+host.Ipaddress,
+			creds.Username,
+			rs.Target.Zone.Id)
+		params.SetClusterid(cluster.Id)
+		if host.Hosttags != "" {
+			params.SetHosttags(splitList(host.Hosttags))
+		}
+		created := host
+		id, err := rs.apply(
+			RestoreResult{Resource: "Host", Name: name, Status: RestoreStatusCreated, Command: "addHost"},
+			params,
+			func() (interface{}, error) { return client.Host.AddHost(params) },
+			&created)
+		if err != nil {
+			return err
+		}
+		created.Id = id
+		rs.Target.Hosts[name] = created
+	}
+	return nil
+}
+
+type RestorePrimaryStoragePools struct{}
+
+func (*RestorePrimaryStoragePools) Name() string {
+	return "primaryStoragePools"
+}
+
+func (*RestorePrimaryStoragePools) Requires() []string {
+	return []string{"clusters", "hosts"}
+}
+
+// storagePoolURL rebuilds the url a pool was created with from the type, address and path CloudStack reports
+func storagePoolURL(pool cloudstack.StoragePool) (string, bool) {
+	switch pool.Type {
+	case "NetworkFilesystem":
+		return "nfs://" + pool.Ipaddress + pool.Path, true
+	case "SharedMountPoint":
+		return "SharedMountPoint://localhost" + pool.Path, true
+	case "PreSetup":
+		return "presetup://" + pool.Ipaddress + pool.Path, true
+	case "VMFS":
+		return "vmfs://" + pool.Ipaddress + pool.Path, true
+	case "CLVM":
+		return "clvm://localhost" + pool.Path, true
+	case "IscsiLUN", "Iscsi":
+		return "iscsi://" + pool.Ipaddress + pool.Path, true
+	case "Gluster":
+		return "gluster://" + pool.Ipaddress + pool.Path, true
+	}
+	return "", false
+}
+
+// update converges the tags and iops capacity of an existing pool with those of the source
+func (*RestorePrimaryStoragePools) update(client *cloudstack.CloudStackClient, rs *Restoration, pool, existing cloudstack.StoragePool) error {
+	var diff changes
+
+	params := client.Pool.NewUpdateStoragePoolParams(existing.Id)
+	if pool.Tags != existing.Tags {
+		params.SetTags(splitList(pool.Tags))
+		diff.add("tags", existing.Tags, pool.Tags)
+	}
+	if pool.Capacityiops > 0 && pool.Capacityiops != existing.Capacityiops {
+		params.SetCapacityiops(pool.Capacityiops)
+		diff.add("capacityiops", existing.Capacityiops, pool.Capacityiops)
+	}
+
+	updated := existing
+	if err := rs.converge(
+		RestoreResult{Resource: "Primary Storage Pool", Name: pool.Name, ID: existing.Id, Command: "updateStoragePool"},
+		diff,
+		params,
+		func() (interface{}, error) { return client.Pool.UpdateStoragePool(params) },
+		&updated); err != nil {
+		return err
+	}
+	rs.Target.PrimaryStoragePools[pool.Name] = updated
+	return nil
+}
+
+// restoreLocal converges a host scoped pool with the local storage of the same host in the target.  Local storage
+// cannot be created through the api, it is registered by a host joining a zone with local storage enabled, so the
+// pool is found through its host, whose address it shares.
+func (rpsp *RestorePrimaryStoragePools) restoreLocal(client *cloudstack.CloudStackClient, rs *Restoration, pool cloudstack.StoragePool) error {
+	var host cloudstack.Host
+	for _, h := range rs.Source.Hosts {
+		if h.Ipaddress == pool.Ipaddress {
+			host = h
+			break
+		}
+	}
+	if host.Name == "" {
+		rs.record("Primary Storage Pool", pool.Name, RestoreStatusSkipped, "", "host "+pool.Ipaddress+" of host scoped pool not present in backup")
+		return nil
+	}
+	target, ok := rs.Target.Hosts[host.Name]
+	if !ok {
+		rs.record("Primary Storage Pool", pool.Name, RestoreStatusSkipped, "", "host "+host.Name+" not present in target zone")
+		return nil
+	}
+	for _, existing := range rs.Target.PrimaryStoragePools {
+		if existing.Scope == StorageScopeHost && existing.Ipaddress == target.Ipaddress {
+			return rpsp.update(client, rs, pool, existing)
+		}
+	}
+	rs.record("Primary Storage Pool", pool.Name, RestoreStatusSkipped, "", "local storage is registered by host "+host.Name+" once local storage is enabled for the zone")
+	return nil
+}
+
+func (rpsp *RestorePrimaryStoragePools) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Primary Storage Pools...")
+	if err := rs.fetch(client, new(FetchPrimaryStoragePools)); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(rs.Source.PrimaryStoragePools))
+	for name := range rs.Source.PrimaryStoragePools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !rs.Selected(name) {
+			continue
+		}
+		pool := rs.Source.PrimaryStoragePools[name]
+		if existing, ok := rs.Target.PrimaryStoragePools[name]; ok {
+			if err := rpsp.update(client, rs, pool, existing); err != nil {
+				return err
+			}
+			continue
+		}
+		if pool.Scope == StorageScopeHost {
+			if err := rpsp.restoreLocal(client, rs, pool); err != nil {
+				return err
+			}
+			continue
+		}
+		// pools of types whose url cannot be rebuilt may only be restored with a url of their own
+		url, ok := rs.Inputs.StorageURLs[name]
+		if !ok {
+			if url, ok = storagePoolURL(pool); !ok {
+				rs.record("Primary Storage Pool", name, RestoreStatusSkipped, "", "unable to determine url for pool type "+pool.Type)
+				continue
+			}
+			url = rs.Inputs.StorageRewrites.Rewrite(url)
+		}
+
+		params := client.Pool.NewCreateStoragePoolParams(pool.Name, url, rs.Target.Zone.Id)
+		params.SetScope(strings.ToLower(pool.Scope))
+		if pool.Scope == StorageScopeCluster {
+			cluster, ok := rs.Target.Clusters[pool.Clustername]
+			if !ok {
+				rs.record("Primary Storage Pool", name, RestoreStatusSkipped, "", "cluster "+pool.Clustername+" not present in target zone")
+				continue
+			}
+			params.SetClusterid(cluster.Id)
+			params.SetPodid(rs.Target.Pods[cluster.Podname].Id)
+		} else if pool.Hypervisor != "" {
+			params.SetHypervisor(pool.Hypervisor)
+		}
+		if pool.Tags != "" {
+			params.SetTags(pool.Tags)
+		}
+		if pool.Capacityiops > 0 {
+			params.SetCapacityiops(pool.Capacityiops)
+		}
+		created := pool
+		id, err := rs.apply(
+			RestoreResult{Resource: "Primary Storage Pool", Name: name, Status: RestoreStatusCreated, Command: "createStoragePool", Message: maskParam("url", url)},
+			params,
+			func() (interface{}, error) { return client.Pool.CreateStoragePool(params) },
+			&created)
+		if err != nil {
+			return err
+		}
+		created.Id = id
+		rs.Target.PrimaryStoragePools[name] = created
+	}
+	return nil
+}
+
+type RestoreSecondaryStoragePools struct{}
+
+func (*RestoreSecondaryStoragePools) Name() string {
+	return "secondaryStoragePools"
+}
+
+func (*RestoreSecondaryStoragePools) Requires() []string {
+	return []string{"zone"}
+}
+
+func (*RestoreSecondaryStoragePools) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Secondary (Image) Storage Pools...")
+	if err := rs.fetch(client, new(FetchSecondaryStoragePools)); err != nil {
+		return err
+	}
+	// region wide stores exist whether or not the zone does, so they are looked up even when it cannot be fetched
+	regional, err := listRegionImageStores(client)
+	if err != nil {
+		return err
+	}
+	for _, store := range regional {
+		if _, ok := rs.Target.SecondaryStoragePools[store.Name]; !ok {
+			rs.Target.SecondaryStoragePools[store.Name] = *store
+		}
+	}
+	// existing stores are matched on url as well, as a store may have been added under another name
+	urls := make(map[string]cloudstack.ImageStore, len(rs.Target.SecondaryStoragePools))
+	for _, store := range rs.Target.SecondaryStoragePools {
+		if store.Url != "" {
+			urls[store.Url] = store
+		}
+	}
+
+	names := make([]string, 0, len(rs.Source.SecondaryStoragePools))
+	for name := range rs.Source.SecondaryStoragePools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !rs.Selected(name) {
+			continue
+		}
+		store := rs.Source.SecondaryStoragePools[name]
+		url := rs.Inputs.StorageRewrites.Rewrite(store.Url)
+		if existing, ok := rs.Target.SecondaryStoragePools[name]; ok {
+			rs.record("Secondary Storage Pool", name, RestoreStatusExisting, existing.Id, "")
+			continue
+		} else if existing, ok = urls[url]; ok && url != "" {
+			rs.record("Secondary Storage Pool", name, RestoreStatusExisting, existing.Id, "")
+			rs.Target.SecondaryStoragePools[name] = existing
+			continue
+		}
+		details, ok := rs.Inputs.ImageStoreSecrets[name]
+		if !ok && store.Providername != ImageStoreProviderNFS {
+			rs.record("Secondary Storage Pool", name, RestoreStatusSkipped, "", "no secrets defined for "+store.Providername+" store")
+			continue
+		}
+		params := client.ImageStore.NewAddImageStoreParams(store.Providername)
+		params.SetName(store.Name)
+		if url != "" {
+			params.SetUrl(url)
+		}
+		if !isRegionImageStore(store) {
+			params.SetZoneid(rs.Target.Zone.Id)
+		}
+		if len(details) > 0 {
+			params.SetDetails(details)
+		}
+		created := store
+		id, err := rs.apply(
+			RestoreResult{Resource: "Secondary Storage Pool", Name: name, Status: RestoreStatusCreated, Command: "addImageStore"},
+			params,
+			func() (interface{}, error) { return client.ImageStore.AddImageStore(params) },
+			&created)
+		if err != nil {
+			return err
+		}
+		created.Id = id
+		rs.Target.SecondaryStoragePools[name] = created
+	}
+	return nil
+}
+
+type RestoreSecondaryStagingStores struct{}
+
+func (*RestoreSecondaryStagingStores) Name() string {
+	return "secondaryStagingStores"
+}
+
+func (*RestoreSecondaryStagingStores) Requires() []string {
+	return []string{"zone"}
+}
+
+func (*RestoreSecondaryStagingStores) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Secondary Staging (Cache) Stores...")
+	if err := rs.fetch(client, new(FetchSecondaryStagingStores)); err != nil {
+		return err
+	}
+
+	// staging stores cannot be named on creation, so existing stores are matched on url as well
+	urls := make(map[string]cloudstack.SecondaryStagingStore, len(rs.Target.SecondaryStagingStores))
+	for _, store := range rs.Target.SecondaryStagingStores {
+		urls[store.Url] = store
+	}
+
+	names := make([]string, 0, len(rs.Source.SecondaryStagingStores))
+	for name := range rs.Source.SecondaryStagingStores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !rs.Selected(name) {
+			continue
+		}
+		store := rs.Source.SecondaryStagingStores[name]
+		url := rs.Inputs.StorageRewrites.Rewrite(store.Url)
+		if existing, ok := rs.Target.SecondaryStagingStores[name]; ok {
+			rs.record("Secondary Staging Store", name, RestoreStatusExisting, existing.Id, "")
+			continue
+		} else if existing, ok = urls[url]; ok {
+			rs.record("Secondary Staging Store", name, RestoreStatusExisting, existing.Id, "")
+			continue
+		}
+		params := client.ImageStore.NewCreateSecondaryStagingStoreParams(url)
+		if store.Providername != "" {
+			params.SetProvider(store.Providername)
+		}
+		if store.Scope != "" {
+			params.SetScope(strings.ToLower(store.Scope))
+		}
+		params.SetZoneid(rs.Target.Zone.Id)
+		if details, ok := rs.Inputs.ImageStoreSecrets[name]; ok {
+			params.SetDetails(details)
+		}
+		created := store
+		id, err := rs.apply(
+			RestoreResult{Resource: "Secondary Staging Store", Name: name, Status: RestoreStatusCreated, Command: "createSecondaryStagingStore"},
+			params,
+			func() (interface{}, error) { return client.ImageStore.CreateSecondaryStagingStore(params) },
+			&created)
+		if err != nil {
+			return err
+		}
+		created.Id = id
+		rs.Target.SecondaryStagingStores[name] = created
+	}
+	return nil
+}
+
+type RestorePhysicalNetworks struct{}
+
+func (*RestorePhysicalNetworks) Name() string {
+	return "physicalNetworks"
+}
+
+func (*RestorePhysicalNetworks) Requires() []string {
+	return []string{"zone"}
+}
+
+// updateTrafficType converges the hypervisor network labels of an existing traffic type with the remapped labels
+// of the source.  Labels absent from the source are left alone, as unused hypervisors are rarely labelled.
+func (*RestorePhysicalNetworks) updateTrafficType(client *cloudstack.CloudStackClient, rs *Restoration, resource string, labels TrafficTypeLabels, existing TrafficType) error {
+	var diff changes
+
+	params := client.Usage.NewUpdateTrafficTypeParams(existing.Id)
+	if labels.HyperV != "" && labels.HyperV != existing.Labels.HyperV {
+		params.SetHypervnetworklabel(labels.HyperV)
+		diff.add("hypervnetworklabel", existing.Labels.HyperV, labels.HyperV)
+	}
+	if labels.KVM != "" && labels.KVM != existing.Labels.KVM {
+		params.SetKvmnetworklabel(labels.KVM)
+		diff.add("kvmnetworklabel", existing.Labels.KVM, labels.KVM)
+	}
+	if labels.OVM3 != "" && labels.OVM3 != existing.Labels.OVM3 {
+		params.SetOvm3networklabel(labels.OVM3)
+		diff.add("ovm3networklabel", existing.Labels.OVM3, labels.OVM3)
+	}
+	if labels.VMware != "" && labels.VMware != existing.Labels.VMware {
+		params.SetVmwarenetworklabel(labels.VMware)
+		diff.add("vmwarenetworklabel", existing.Labels.VMware, labels.VMware)
+	}
+	if labels.XenServer != "" && labels.XenServer != existing.Labels.XenServer {
+		params.SetXennetworklabel(labels.XenServer)
+		diff.add("xennetworklabel", existing.Labels.XenServer, labels.XenServer)
+	}
+
+	return rs.converge(
+		RestoreResult{Resource: "Traffic Type", Name: resource, ID: existing.Id, Command: "updateTrafficType"},
+		diff,
+		params,
+		func() (interface{}, error) { return client.Usage.UpdateTrafficType(params) },
+		nil)
+}
+
+func (rpn *RestorePhysicalNetworks) restoreTrafficTypes(client *cloudstack.CloudStackClient, rs *Restoration, src PhysicalNetwork, target *PhysicalNetwork) error {
+	names := make([]string, 0, len(src.TrafficTypes))
+	for name := range src.TrafficTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		resource := src.Name + "/" + name
+		labels := rs.Inputs.NetworkLabels.Remap(src.TrafficTypes[name].Labels)
+		if existing, ok := target.TrafficTypes[name]; ok {
+			if err := rpn.updateTrafficType(client, rs, resource, labels, existing); err != nil {
+				return err
+			}
+			continue
+		}
+		params := client.Usage.NewAddTrafficTypeParams(target.Id, name)
+		if labels.HyperV != "" {
+			params.SetHypervnetworklabel(labels.HyperV)
+		}
+		if labels.KVM != "" {
+			params.SetKvmnetworklabel(labels.KVM)
+		}
+		if labels.OVM3 != "" {
+			params.SetOvm3networklabel(labels.OVM3)
+		}
+		if labels.VMware != "" {
+			params.SetVmwarenetworklabel(labels.VMware)
+		}
+		if labels.XenServer != "" {
+			params.SetXennetworklabel(labels.XenServer)
+		}
+		created := TrafficType{
+			TrafficType: src.TrafficTypes[name].TrafficType,
+			Labels:      labels,
+			Networks:    make(map[string]cloudstack.Network),
+		}
+		created.Physicalnetworkid = target.Id
+		id, err := rs.apply(
+			RestoreResult{Resource: "Traffic Type", Name: resource, Status: RestoreStatusCreated, Command: "addTrafficType"},
+			params,
+			func() (interface{}, error) { return client.Usage.AddTrafficType(params) },
+			&created.TrafficType)
+		if err != nil {
+			return err
+		}
+		created.Id = id
+		target.TrafficTypes[name] = created
+	}
+	return nil
+}
+
+// update converges the vlan, tags and speed of an existing physical network with those of the source.  The state
+// is handled separately, as a network may only be enabled once its traffic types exist.
+func (*RestorePhysicalNetworks) update(client *cloudstack.CloudStackClient, rs *Restoration, src PhysicalNetwork, target *PhysicalNetwork) error {
+	var diff changes
+
+	params := client.Network.NewUpdatePhysicalNetworkParams(target.Id)
+	if src.Vlan != "" && src.Vlan != target.Vlan {
+		params.SetVlan(src.Vlan)
+		diff.add("vlan", target.Vlan, src.Vlan)
+	}
+	if src.Tags != target.Tags {
+		params.SetTags(splitList(src.Tags))
+		diff.add("tags", target.Tags, src.Tags)
+	}
+	if src.Networkspeed != "" && src.Networkspeed != target.Networkspeed {
+		params.SetNetworkspeed(src.Networkspeed)
+		diff.add("networkspeed", target.Networkspeed, src.Networkspeed)
+	}
+
+	return rs.converge(
+		RestoreResult{Resource: "Physical Network", Name: src.Name, ID: target.Id, Command: "updatePhysicalNetwork"},
+		diff,
+		params,
+		func() (interface{}, error) { return client.Network.UpdatePhysicalNetwork(params) },
+		&target.PhysicalNetwork)
+}
+
+func (rpn *RestorePhysicalNetworks) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Physical Networks...")
+	if err := rs.fetch(client, new(FetchPhysicalNetworks)); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(rs.Source.PhysicalNetworks))
+	for name := range rs.Source.PhysicalNetworks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !rs.Selected(name) {
+			continue
+		}
+		src := rs.Source.PhysicalNetworks[name]
+		target, ok := rs.Target.PhysicalNetworks[name]
+		if ok {
+			if err := rpn.update(client, rs, src, &target); err != nil {
+				return err
+			}
+		} else {
+			params := client.Network.NewCreatePhysicalNetworkParams(src.Name, rs.Target.Zone.Id)
+			if src.Isolationmethods != "" {
+				params.SetIsolationmethods(strings.Split(src.Isolationmethods, ","))
+			}
+			if src.Vlan != "" {
+				params.SetVlan(src.Vlan)
+			}
+			if src.Tags != "" {
+				params.SetTags(strings.Split(src.Tags, ","))
+			}
+			if src.Broadcastdomainrange != "" {
+				params.SetBroadcastdomainrange(src.Broadcastdomainrange)
+			}
+			if src.Networkspeed != "" {
+				params.SetNetworkspeed(src.Networkspeed)
+			}
+			// new networks are disabled until their traffic types have been added
+			target = PhysicalNetwork{PhysicalNetwork: src.PhysicalNetwork, TrafficTypes: make(map[string]TrafficType)}
+			target.State = "Disabled"
+			id, err := rs.apply(
+				RestoreResult{Resource: "Physical Network", Name: name, Status: RestoreStatusCreated, Command: "createPhysicalNetwork"},
+				params,
+				func() (interface{}, error) { return client.Network.CreatePhysicalNetwork(params) },
+				&target.PhysicalNetwork)
+			if err != nil {
+				return err
+			}
+			target.Id = id
+		}
+
+		if err := rpn.restoreTrafficTypes(client, rs, src, &target); err != nil {
+			return err
+		}
+
+		// networks are enabled only once their traffic types are in place
+		if src.State == PhysicalNetworkStateEnabled && target.State != PhysicalNetworkStateEnabled {
+			params := client.Network.NewUpdatePhysicalNetworkParams(target.Id)
+			params.SetState(PhysicalNetworkStateEnabled)
+			_, err := rs.apply(
+				RestoreResult{Resource: "Physical Network", Name: name, Status: RestoreStatusUpdated, ID: target.Id, Message: "enabled", Command: "updatePhysicalNetwork"},
+				params,
+				func() (interface{}, error) { return client.Network.UpdatePhysicalNetwork(params) },
+				nil)
+			if err != nil {
+				return err
+			}
+			target.State = PhysicalNetworkStateEnabled
+		}
+
+		rs.Target.PhysicalNetworks[name] = target
+	}
+	return nil
+}
+
+type RestoreComputeOfferings struct{}
+
+func (*RestoreComputeOfferings) Name() string {
+	return "computeOfferings"
+}
+
+func (*RestoreComputeOfferings) Requires() []string {
+	return nil
+}
+
+// update converges the display text of an existing offering with that of the source.  CloudStack does not allow
+// the resources of an offering to be changed once it has been created.
+func (*RestoreComputeOfferings) update(client *cloudstack.CloudStackClient, rs *Restoration, offering, existing cloudstack.ServiceOffering) error {
+	var diff changes
+
+	params := client.ServiceOffering.NewUpdateServiceOfferingParams(existing.Id)
+	if offering.Displaytext != existing.Displaytext {
+		params.SetDisplaytext(offering.Displaytext)
+		diff.add("displaytext", existing.Displaytext, offering.Displaytext)
+	}
+
+	updated := existing
+	if err := rs.converge(
+		RestoreResult{Resource: "Compute Offering", Name: offering.Name, ID: existing.Id, Command: "updateServiceOffering"},
+		diff,
+		params,
+		func() (interface{}, error) { return client.ServiceOffering.UpdateServiceOffering(params) },
+		&updated); err != nil {
+		return err
+	}
+	rs.Target.ComputeOfferings[offering.Name] = updated
+	return nil
+}
+
+func (rco *RestoreComputeOfferings) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Compute Offerings...")
+	if err := new(FetchComputeOfferings).Fetch(client, rs.Target); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(rs.Source.ComputeOfferings))
+	for name := range rs.Source.ComputeOfferings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !rs.Selected(name) {
+			continue
+		}
+		offering := rs.Source.ComputeOfferings[name]
+		if existing, ok := rs.Target.ComputeOfferings[name]; ok {
+			if err := rco.update(client, rs, offering, existing); err != nil {
+				return err
+			}
+			continue
+		}
+		params := client.ServiceOffering.NewCreateServiceOfferingParams(offering.Displaytext, offering.Name)
+		if offering.Domain != "" {
+			domainID, err := rs.domainID(client, offering.Domain)
+			if err != nil {
+				return err
+			}
+			params.SetDomainid(domainID)
+		}
+		// customized offerings are those created without cpu and memory values
+		if offering.Cpunumber > 0 {
+			params.SetCpunumber(offering.Cpunumber)
+		}
+		if offering.Cpuspeed > 0 {
+			params.SetCpuspeed(offering.Cpuspeed)
+		}
+		if offering.Memory > 0 {
+			params.SetMemory(offering.Memory)
+		}
+		if offering.Iscustomizediops {
+			params.SetCustomizediops(true)
+		} else {
+			if offering.Miniops > 0 {
+				params.SetMiniops(offering.Miniops)
+			}
+			if offering.Maxiops > 0 {
+				params.SetMaxiops(offering.Maxiops)
+			}
+		}
+		if offering.DiskBytesReadRate > 0 {
+			params.SetBytesreadrate(offering.DiskBytesReadRate)
+		}
+		if offering.DiskBytesWriteRate > 0 {
+			params.SetByteswriterate(offering.DiskBytesWriteRate)
+		}
+		if offering.DiskIopsReadRate > 0 {
+			params.SetIopsreadrate(offering.DiskIopsReadRate)
+		}
+		if offering.DiskIopsWriteRate > 0 {
+			params.SetIopswriterate(offering.DiskIopsWriteRate)
+		}
+		if offering.Hypervisorsnapshotreserve > 0 {
+			params.SetHypervisorsnapshotreserve(offering.Hypervisorsnapshotreserve)
+		}
+		if offering.Networkrate > 0 {
+			params.SetNetworkrate(offering.Networkrate)
+		}
+		if offering.Hosttags != "" {
+			params.SetHosttags(offering.Hosttags)
+		}
+		if offering.Tags != "" {
+			params.SetTags(offering.Tags)
+		}
+		if offering.Storagetype != "" {
+			params.SetStoragetype(offering.Storagetype)
+		}
+		if offering.Provisioningtype != "" {
+			params.SetProvisioningtype(offering.Provisioningtype)
+		}
+		if offering.Deploymentplanner != "" {
+			params.SetDeploymentplanner(offering.Deploymentplanner)
+		}
+		if len(offering.Serviceofferingdetails) > 0 {
+			params.SetServiceofferingdetails(offering.Serviceofferingdetails)
+		}
+		params.SetOfferha(offering.Offerha)
+		params.SetLimitcpuuse(offering.Limitcpuuse)
+		params.SetIsvolatile(offering.Isvolatile)
+		created := offering
+		id, err := rs.apply(
+			RestoreResult{Resource: "Compute Offering", Name: name, Status: RestoreStatusCreated, Command: "createServiceOffering"},
+			params,
+			func() (interface{}, error) { return client.ServiceOffering.CreateServiceOffering(params) },
+			&created)
+		if err != nil {
+			return err
+		}
+		created.Id = id
+		rs.Target.ComputeOfferings[name] = created
+	}
+	return nil
+}
+
+type RestoreDiskOfferings struct{}
+
+func (*RestoreDiskOfferings) Name() string {
+	return "diskOfferings"
+}
+
+func (*RestoreDiskOfferings) Requires() []string {
+	return nil
+}
+
+// update converges the display text and visibility of an existing offering with those of the source
+func (*RestoreDiskOfferings) update(client *cloudstack.CloudStackClient, rs *Restoration, offering, existing cloudstack.DiskOffering) error {
+	var diff changes
+
+	params := client.DiskOffering.NewUpdateDiskOfferingParams(existing.Id)
+	if offering.Displaytext != existing.Displaytext {
+		params.SetDisplaytext(offering.Displaytext)
+		diff.add("displaytext", existing.Displaytext, offering.Displaytext)
+	}
+	if offering.Displayoffering != existing.Displayoffering {
+		params.SetDisplayoffering(offering.Displayoffering)
+		diff.add("displayoffering", existing.Displayoffering, offering.Displayoffering)
+	}
+
+	updated := existing
+	if err := rs.converge(
+		RestoreResult{Resource: "Disk Offering", Name: offering.Name, ID: existing.Id, Command: "updateDiskOffering"},
+		diff,
+		params,
+		func() (interface{}, error) { return client.DiskOffering.UpdateDiskOffering(params) },
+		&updated); err != nil {
+		return err
+	}
+	rs.Target.DiskOfferings[offering.Name] = updated
+	return nil
+}
+
+func (rdo *RestoreDiskOfferings) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Disk Offerings...")
+	if err := new(FetchDiskOfferings).Fetch(client, rs.Target); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(rs.Source.DiskOfferings))
+	for name := range rs.Source.DiskOfferings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !rs.Selected(name) {
+			continue
+		}
+		offering := rs.Source.DiskOfferings[name]
+		if existing, ok := rs.Target.DiskOfferings[name]; ok {
+			if err := rdo.update(client, rs, offering, existing); err != nil {
+				return err
+			}
+			continue
+		}
+		params := client.DiskOffering.NewCreateDiskOfferingParams(offering.Displaytext, offering.Name)
+		if offering.Domain != "" {
+			domainID, err := rs.domainID(client, offering.Domain)
+			if err != nil {
+				return err
+			}
+			params.SetDomainid(domainID)
+		}
+		if offering.Iscustomized {
+			params.SetCustomized(true)
+		} else if offering.Disksize > 0 {
+			params.SetDisksize(offering.Disksize)
+		}
+		if offering.Iscustomizediops {
+			params.SetCustomizediops(true)
+		} else {
+			if offering.Miniops > 0 {
+				params.SetMiniops(offering.Miniops)
+			}
+			if offering.Maxiops > 0 {
+				params.SetMaxiops(offering.Maxiops)
+			}
+		}
+		if offering.DiskBytesReadRate > 0 {
+			params.SetBytesreadrate(offering.DiskBytesReadRate)
+		}
+		if offering.DiskBytesWriteRate > 0 {
+			params.SetByteswriterate(offering.DiskBytesWriteRate)
+		}
+		if offering.DiskIopsReadRate > 0 {
+			params.SetIopsreadrate(offering.DiskIopsReadRate)
+		}
+		if offering.DiskIopsWriteRate > 0 {
+			params.SetIopswriterate(offering.DiskIopsWriteRate)
+		}
+		if offering.Hypervisorsnapshotreserve > 0 {
+			params.SetHypervisorsnapshotreserve(offering.Hypervisorsnapshotreserve)
+		}
+		if offering.Tags != "" {
+			params.SetTags(offering.Tags)
+		}
+		if offering.Storagetype != "" {
+			params.SetStoragetype(offering.Storagetype)
+		}
+		if offering.Provisioningtype != "" {
+			params.SetProvisioningtype(offering.Provisioningtype)
+		}
+		params.SetDisplayoffering(offering.Displayoffering)
+		created := offering
+		id, err := rs.apply(
+			RestoreResult{Resource: "Disk Offering", Name: name, Status: RestoreStatusCreated, Command: "createDiskOffering"},
+			params,
+			func() (interface{}, error) { return client.DiskOffering.CreateDiskOffering(params) },
+			&created)
+		if err != nil {
+			return err
+		}
+		created.Id = id
+		rs.Target.DiskOfferings[name] = created
+	}
+	return nil
+}
+
+// restoreConfigurations updates each allowed key in target whose value differs from that in source
+func restoreConfigurations(client *cloudstack.CloudStackClient, rs *Restoration, resource string, source, target map[string]cloudstack.Configuration, zoneID string) error {
+	names := make([]string, 0, len(source))
+	for name := range source {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !rs.Selected(name) {
+			continue
+		}
+		config := source[name]
+		existing, ok := target[name]
+		if !ok {
+			rs.record(resource, name, RestoreStatusSkipped, "", "not present in target")
+			continue
+		}
+		if existing.Value == config.Value {
+			continue
+		}
+		if !rs.Inputs.Configuration.Allowed(name) {
+			rs.record(resource, name, RestoreStatusSkipped, "", "excluded by filter")
+			continue
+		}
+		params := client.Configuration.NewUpdateConfigurationParams(name)
+		params.SetValue(config.Value)
+		if zoneID != "" {
+			params.SetZoneid(zoneID)
+		}
+		message := existing.Value + " => " + config.Value
+		if isSecretParam(name) {
+			message = "value changed"
+		}
+		updated := existing
+		updated.Value = config.Value
+		_, err := rs.apply(
+			RestoreResult{Resource: resource, Name: name, Status: RestoreStatusUpdated, Message: message, Command: "updateConfiguration"},
+			params,
+			func() (interface{}, error) { return client.Configuration.UpdateConfiguration(params) },
+			&updated)
+		if err != nil {
+			return err
+		}
+		target[name] = updated
+	}
+	return nil
+}
+
+type RestoreZoneConfigurations struct{}
+
+func (*RestoreZoneConfigurations) Name() string {
+	return "zoneConfigs"
+}
+
+func (*RestoreZoneConfigurations) Requires() []string {
+	return []string{"zone"}
+}
+
+func (*RestoreZoneConfigurations) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Zone Configurations...")
+	if err := rs.fetch(client, new(FetchZoneConfigurations)); err != nil {
+		return err
+	}
+	return restoreConfigurations(client, rs, "Zone Configuration", rs.Source.ZoneConfiguration, rs.Target.ZoneConfiguration, rs.Target.Zone.Id)
+}
+
+type RestoreGlobalConfigurations struct{}
+
+func (*RestoreGlobalConfigurations) Name() string {
+	return "globalConfigs"
+}
+
+func (*RestoreGlobalConfigurations) Requires() []string {
+	return nil
+}
+
+func (*RestoreGlobalConfigurations) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Global Configurations...")
+	if err := new(FetchGlobalConfigurations).Fetch(client, rs.Target); err != nil {
+		return err
+	}
+	return restoreConfigurations(client, rs, "Global Configuration", rs.Source.GlobalConfiguration, rs.Target.GlobalConfiguration, "")
+}
+
+type RestoreTemplates struct{}
+
+func (*RestoreTemplates) Name() string {
+	return "templates"
+}
+
+// Requires includes enableZone as templates are downloaded by the secondary storage vm, which only runs in an
+// enabled zone
+func (*RestoreTemplates) Requires() []string {
+	return []string{"enableZone", "secondaryStoragePools"}
+}
+
+func (*RestoreTemplates) register(client *cloudstack.CloudStackClient, rs *Restoration, template cloudstack.Template, url string) (cloudstack.Template, error) {
+	created := cloudstack.Template{}
+	osTypeID, err := rs.osTypeID(client, template.Ostypename)
+	if err != nil {
+		return created, err
+	}
+	params := client.Template.NewRegisterTemplateParams(
+		template.Displaytext,
+		template.Format,
+		template.Hypervisor,
+		template.Name,
+		osTypeID,
+		url,
+		rs.Target.Zone.Id)
+	if template.Domain != "" {
+		domainID, err := rs.domainID(client, template.Domain)
+		if err != nil {
+			return created, err
+		}
+		params.SetDomainid(domainID)
+		if template.Account != "" {
+			params.SetAccount(template.Account)
+		}
+	}
+	if template.Checksum != "" {
+		params.SetChecksum(template.Checksum)
+	}
+	if template.Templatetag != "" {
+		params.SetTemplatetag(template.Templatetag)
+	}
+	if len(template.Details) > 0 {
+		params.SetDetails(template.Details)
+	}
+	params.SetIspublic(template.Ispublic)
+	params.SetIsfeatured(template.Isfeatured)
+	params.SetIsextractable(template.Isextractable)
+	params.SetIsdynamicallyscalable(template.Isdynamicallyscalable)
+	params.SetPasswordenabled(template.Passwordenabled)
+	params.SetSshkeyenabled(template.Sshkeyenabled)
+	created = template
+	created.Isready = false
+	id, err := rs.apply(
+		RestoreResult{Resource: "Template", Name: template.Name, Status: RestoreStatusCreated, Command: "registerTemplate"},
+		params,
+		func() (interface{}, error) {
+			resp, err := client.Template.RegisterTemplate(params)
+			if err != nil {
+				return nil, err
+			}
+			if len(resp.RegisterTemplate) == 0 {
+				return nil, fmt.Errorf("registration of template %s returned no template", template.Name)
+			}
+			return resp.RegisterTemplate[0], nil
+		},
+		&created)
+	created.Id = id
+	return created, err
+}
+
+// update converges the display text, os type and guest options of an existing template with those of the source
+func (*RestoreTemplates) update(client *cloudstack.CloudStackClient, rs *Restoration, template, existing cloudstack.Template) error {
+	var diff changes
+
+	params := client.Template.NewUpdateTemplateParams(existing.Id)
+	if template.Displaytext != existing.Displaytext {
+		params.SetDisplaytext(template.Displaytext)
+		diff.add("displaytext", existing.Displaytext, template.Displaytext)
+	}
+	if template.Ostypename != existing.Ostypename {
+		osTypeID, err := rs.osTypeID(client, template.Ostypename)
+		if err != nil {
+			return err
+		}
+		params.SetOstypeid(osTypeID)
+		diff.add("ostype", existing.Ostypename, template.Ostypename)
+	}
+	if template.Passwordenabled != existing.Passwordenabled {
+		params.SetPasswordenabled(template.Passwordenabled)
+		diff.add("passwordenabled", existing.Passwordenabled, template.Passwordenabled)
+	}
+	if template.Isdynamicallyscalable != existing.Isdynamicallyscalable {
+		params.SetIsdynamicallyscalable(template.Isdynamicallyscalable)
+		diff.add("isdynamicallyscalable", existing.Isdynamicallyscalable, template.Isdynamicallyscalable)
+	}
+
+	updated := existing
+	if err := rs.converge(
+		RestoreResult{Resource: "Template", Name: template.Name, ID: existing.Id, Command: "updateTemplate"},
+		diff,
+		params,
+		func() (interface{}, error) { return client.Template.UpdateTemplate(params) },
+		&updated); err != nil {
+		return err
+	}
+	rs.Target.Templates[template.Name] = updated
+	return nil
+}
+
+// wait polls the registered templates until all have finished downloading
+func (*RestoreTemplates) wait(client *cloudstack.CloudStackClient, rs *Restoration, pending map[string]string) error {
+	timeout := rs.Options.TemplateTimeout
+	if timeout <= 0 {
+		timeout = DefaultTemplateTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for len(pending) > 0 {
+		for name, id := range pending {
+			template, _, err := client.Template.GetTemplateByID(id, "all")
+			if err != nil {
+				return err
+			}
+			if template.Isready {
+				log.Println("  Template " + name + " ready")
+				rs.Target.Templates[name] = *template
+				delete(pending, name)
+				continue
+			}
+			status := strings.ToLower(template.Status)
+			if strings.Contains(status, "fail") || strings.Contains(status, "error") {
+				return fmt.Errorf("template %s failed to download: %s", name, template.Status)
+			}
+			log.Printf("  Template %s: %s", name, template.Status)
+		}
+		if len(pending) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %d template(s) to download", timeout, len(pending))
+		}
+		time.Sleep(10 * time.Second)
+	}
+	return nil
+}
+
+func (rt *RestoreTemplates) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	log.Println("Restoring Templates...")
+	if err := rs.fetch(client, new(FetchTemplates)); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(rs.Source.Templates))
+	for name := range rs.Source.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// template name => new template id
+	pending := make(map[string]string)
+
+	for _, name := range names {
+		if !rs.Selected(name) {
+			continue
+		}
+		template := rs.Source.Templates[name]
+		switch template.Templatetype {
+		case TemplateTypeSystem, TemplateTypeBuiltin, TemplateTypeRouting:
+			if !rs.Options.IncludeSystemTemplates {
+				continue
+			}
+		}
+		if existing, ok := rs.Target.Templates[name]; ok {
+			if err := rt.update(client, rs, template, existing); err != nil {
+				return err
+			}
+			// a download begun by an interrupted run is waited on just as a new one is
+			if !existing.Isready && !isPlaceholderID(existing.Id) {
+				pending[name] = existing.Id
+			}
+			continue
+		}
+		url, ok := rs.Inputs.TemplateURLs.For(template)
+		if !ok {
+			rs.record("Template", name, RestoreStatusSkipped, "", "no url defined")
+			continue
+		}
+		created, err := rt.register(client, rs, template, url)
+		if err != nil {
+			return err
+		}
+		rs.Target.Templates[name] = created
+		pending[name] = created.Id
+	}
+
+	if len(pending) > 0 && !rs.Options.DryRun {
+		log.Printf("Waiting for %d Template(s) to download...", len(pending))
+		return rt.wait(client, rs, pending)
+	}
+	return nil
+}
+
+type EnableZone struct{}
+
+func (*EnableZone) Name() string {
+	return "enableZone"
+}
+
+func (*EnableZone) Requires() []string {
+	return []string{
+		"physicalNetworks",
+		"pods",
+		"clusters",
+		"hosts",
+		"primaryStoragePools",
+		"secondaryStoragePools",
+		"secondaryStagingStores",
+		"zoneConfigs",
+		"globalConfigs",
+	}
+}
+
+func (*EnableZone) Restore(client *cloudstack.CloudStackClient, rs *Restoration) error {
+	if rs.Target.Zone.Id == "" || !rs.Selected(rs.Source.Zone.Name) {
+		return nil
+	}
+	if rs.Source.Zone.Allocationstate != AllocationStateEnabled {
+		return nil
+	}
+	if rs.Target.Zone.Allocationstate == AllocationStateEnabled {
+		return nil
+	}
+	log.Println("Enabling Zone " + rs.Target.Zone.Name + "...")
+	params := client.Zone.NewUpdateZoneParams(rs.Target.Zone.Id)
+	params.SetAllocationstate(AllocationStateEnabled)
+	_, err := rs.apply(
+		RestoreResult{Resource: "Zone", Name: rs.Target.Zone.Name, Status: RestoreStatusUpdated, ID: rs.Target.Zone.Id, Message: "enabled", Command: "updateZone"},
+		params,
+		func() (interface{}, error) { return client.Zone.UpdateZone(params) },
+		nil)
+	if err != nil {
+		return err
+	}
+	rs.Target.Zone.Allocationstate = AllocationStateEnabled
+	return nil
+}
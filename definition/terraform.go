@@ -0,0 +1,345 @@
+package definition
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The terraform formatters describe a zone with the resources of the CloudStack Terraform provider, each resource
+// referring to those it belongs to rather than to their ids.  FormatTerraform writes the configuration and
+// FormatTerraformImport a script which imports the existing resources into state under the addresses the
+// configuration gives them, so the zone can be adopted by Terraform without being recreated.
+
+type (
+	// tfExpr is written to a configuration as is, rather than as a string
+	tfExpr string
+
+	tfAttr struct {
+		name  string
+		value interface{}
+	}
+
+	tfResource struct {
+		kind  string
+		label string
+		id    string
+		attrs []tfAttr
+	}
+
+	// tfBuilder collects the resources of a definition along with the labels given to them
+	tfBuilder struct {
+		resources []*tfResource
+		labels    map[string]map[string]string
+		used      map[string]map[string]bool
+		skipped   []string
+		variables []string
+	}
+)
+
+var tfInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// FormatTerraform renders a definition as Terraform configuration for the CloudStack provider.  Resources which
+// restore would skip, such as system hosts, are left out and noted in a comment.
+func FormatTerraform(zd *ZoneDefinition) ([]byte, error) {
+	if zd == nil {
+		return nil, errors.New("zone definition cannot be empty")
+	}
+	tb := buildTerraform(zd)
+
+	buf := new(bytes.Buffer)
+	fmt.Fprintf(buf, "# Zone %s\n", strings.Replace(zd.Zone.Name, "\n", " ", -1))
+	for _, s := range tb.skipped {
+		fmt.Fprintf(buf, "# Not included: %s\n", s)
+	}
+	for _, name := range tb.variables {
+		fmt.Fprintf(buf, "\nvariable %s {\n  type      = string\n  sensitive = true\n}\n", strconv.Quote(name))
+	}
+	for _, r := range tb.resources {
+		fmt.Fprintf(buf, "\nresource %s %s {\n", strconv.Quote(r.kind), strconv.Quote(r.label))
+		width := 0
+		for _, a := range r.attrs {
+			if len(a.name) > width {
+				width = len(a.name)
+			}
+		}
+		for _, a := range r.attrs {
+			fmt.Fprintf(buf, "  %-*s = %s\n", width, a.name, hclValue(a.value))
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.Bytes(), nil
+}
+
+// FormatTerraformImport renders a shell script importing every resource of FormatTerraform's configuration which
+// has an id
+func FormatTerraformImport(zd *ZoneDefinition) ([]byte, error) {
+	if zd == nil {
+		return nil, errors.New("zone definition cannot be empty")
+	}
+	tb := buildTerraform(zd)
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(buf, "# Imports the resources of zone %s into Terraform state\n", strings.Replace(zd.Zone.Name, "\n", " ", -1))
+	buf.WriteString("set -e\n\n")
+	for _, r := range tb.resources {
+		if r.id == "" || isPlaceholderID(r.id) {
+			continue
+		}
+		fmt.Fprintf(buf, "terraform import %s.%s '%s'\n", r.kind, r.label, strings.Replace(r.id, "'", `'\''`, -1))
+	}
+	return buf.Bytes(), nil
+}
+
+func buildTerraform(zd *ZoneDefinition) *tfBuilder {
+	tb := &tfBuilder{
+		labels: make(map[string]map[string]string),
+		used:   make(map[string]map[string]bool),
+	}
+
+	zone := tb.add("cloudstack_zone", zd.Zone.Name, zd.Zone.Id)
+	zone.set("name", zd.Zone.Name)
+	zone.set("dns1", zd.Zone.Dns1)
+	zone.set("dns2", zd.Zone.Dns2)
+	zone.set("internal_dns1", zd.Zone.Internaldns1)
+	zone.set("internal_dns2", zd.Zone.Internaldns2)
+	zone.set("ip6_dns1", zd.Zone.Ip6dns1)
+	zone.set("ip6_dns2", zd.Zone.Ip6dns2)
+	zone.set("network_type", zd.Zone.Networktype)
+	zone.set("domain", zd.Zone.Domain)
+	zone.set("guest_cidr_address", zd.Zone.Guestcidraddress)
+	zone.set("local_storage_enabled", zd.Zone.Localstorageenabled)
+	zone.set("security_group_enabled", zd.Zone.Securitygroupsenabled)
+	zoneID := tb.ref("cloudstack_zone", zd.Zone.Name, zd.Zone.Id)
+
+	for _, name := range sortedKeys(zd.PhysicalNetworks) {
+		pn := zd.PhysicalNetworks[name]
+		r := tb.add("cloudstack_physical_network", name, pn.Id)
+		r.set("name", name)
+		r.set("zone_id", zoneID)
+		r.set("broadcast_domain_range", pn.Broadcastdomainrange)
+		r.set("isolation_methods", splitList(pn.Isolationmethods))
+		r.set("network_speed", pn.Networkspeed)
+		r.set("vlan", pn.Vlan)
+		r.set("tags", splitList(pn.Tags))
+
+		for _, tt := range sortedKeys(pn.TrafficTypes) {
+			t := pn.TrafficTypes[tt]
+			r := tb.add("cloudstack_traffic_type", name+"_"+tt, t.Id)
+			r.set("physical_network_id", tb.ref("cloudstack_physical_network", name, pn.Id))
+			r.set("type", tt)
+			r.set("hyperv_network_label", t.Labels.HyperV)
+			r.set("kvm_network_label", t.Labels.KVM)
+			r.set("ovm3_network_label", t.Labels.OVM3)
+			r.set("vmware_network_label", t.Labels.VMware)
+			r.set("xen_network_label", t.Labels.XenServer)
+		}
+	}
+
+	for _, name := range sortedKeys(zd.Pods) {
+		pod := zd.Pods[name]
+		r := tb.add("cloudstack_pod", name, pod.Id)
+		r.set("name", name)
+		r.set("zone_id", zoneID)
+		r.set("gateway", pod.Gateway)
+		r.set("netmask", pod.Netmask)
+		r.set("start_ip", pod.Startip)
+		r.set("end_ip", pod.Endip)
+		r.set("allocation_state", pod.Allocationstate)
+	}
+
+	for _, name := range sortedKeys(zd.Clusters) {
+		cluster := zd.Clusters[name]
+		r := tb.add("cloudstack_cluster", name, cluster.Id)
+		r.set("cluster_name", name)
+		r.set("cluster_type", cluster.Clustertype)
+		r.set("hypervisor", cluster.Hypervisortype)
+		r.set("pod_id", tb.ref("cloudstack_pod", cluster.Podname, cluster.Podid))
+		r.set("zone_id", zoneID)
+		r.set("allocation_state", cluster.Allocationstate)
+	}
+
+	for _, name := range sortedKeys(zd.Hosts) {
+		host := zd.Hosts[name]
+		if host.Type != HostTypeRouting {
+			continue
+		}
+		if host.Hypervisor == "VMware" {
+			tb.skipped = append(tb.skipped, fmt.Sprintf("host %s, VMware hosts are discovered through their cluster", name))
+			continue
+		}
+		tb.variable("host_username")
+		tb.variable("host_password")
+		r := tb.add("cloudstack_host", name, host.Id)
+		r.set("hypervisor", host.Hypervisor)
+		r.set("url", "http://"+host.Ipaddress)
+		r.set("username", tfExpr("var.host_username"))
+		r.set("password", tfExpr("var.host_password"))
+		r.set("zone_id", zoneID)
+		r.set("pod_id", tb.ref("cloudstack_pod", host.Podname, host.Podid))
+		r.set("cluster_id", tb.ref("cloudstack_cluster", host.Clustername, host.Clusterid))
+		r.set("host_tags", splitList(host.Hosttags))
+	}
+
+	for _, name := range sortedKeys(zd.PrimaryStoragePools) {
+		pool := zd.PrimaryStoragePools[name]
+		if pool.Scope == StorageScopeHost {
+			continue
+		}
+		url, ok := storagePoolURL(pool)
+		if !ok {
+			tb.skipped = append(tb.skipped, fmt.Sprintf("primary storage pool %s, unable to determine url for pool type %s", name, pool.Type))
+			continue
+		}
+		r := tb.add("cloudstack_storage_pool", name, pool.Id)
+		r.set("name", name)
+		r.set("url", url)
+		r.set("scope", strings.ToLower(pool.Scope))
+		r.set("zone_id", zoneID)
+		if pool.Scope == StorageScopeCluster {
+			cluster := zd.Clusters[pool.Clustername]
+			r.set("pod_id", tb.ref("cloudstack_pod", cluster.Podname, cluster.Podid))
+			r.set("cluster_id", tb.ref("cloudstack_cluster", pool.Clustername, pool.Clusterid))
+		} else {
+			r.set("hypervisor", pool.Hypervisor)
+		}
+		r.set("tags", splitList(pool.Tags))
+		if pool.Capacityiops > 0 {
+			r.set("capacity_iops", pool.Capacityiops)
+		}
+	}
+
+	for _, name := range sortedKeys(zd.ComputeOfferings) {
+		offering := zd.ComputeOfferings[name]
+		r := tb.add("cloudstack_service_offering", name, offering.Id)
+		r.set("name", name)
+		r.set("display_text", offering.Displaytext)
+		if offering.Cpunumber > 0 {
+			r.set("cpu_number", offering.Cpunumber)
+		}
+		if offering.Cpuspeed > 0 {
+			r.set("cpu_speed", offering.Cpuspeed)
+		}
+		if offering.Memory > 0 {
+			r.set("memory", offering.Memory)
+		}
+		r.set("host_tags", offering.Hosttags)
+		r.set("storage_type", offering.Storagetype)
+		r.set("offer_ha", offering.Offerha)
+		r.set("limit_cpu_use", offering.Limitcpuuse)
+	}
+
+	for _, name := range sortedKeys(zd.DiskOfferings) {
+		offering := zd.DiskOfferings[name]
+		r := tb.add("cloudstack_disk_offering", name, offering.Id)
+		r.set("name", name)
+		r.set("display_text", offering.Displaytext)
+		if !offering.Iscustomized && offering.Disksize > 0 {
+			r.set("disk_size", offering.Disksize)
+		}
+	}
+
+	return tb
+}
+
+// add creates a resource of kind, labelled after the name of the resource it describes
+func (tb *tfBuilder) add(kind, name, id string) *tfResource {
+	if tb.used[kind] == nil {
+		tb.used[kind] = make(map[string]bool)
+		tb.labels[kind] = make(map[string]string)
+	}
+	base := tfInvalid.ReplaceAllString(strings.ToLower(name), "_")
+	if base == "" || (base[0] >= '0' && base[0] <= '9') || base[0] == '-' {
+		base = "_" + base
+	}
+	label := base
+	for i := 2; tb.used[kind][label]; i++ {
+		label = fmt.Sprintf("%s_%d", base, i)
+	}
+	tb.used[kind][label] = true
+	tb.labels[kind][name] = label
+
+	r := &tfResource{kind: kind, label: label, id: id}
+	tb.resources = append(tb.resources, r)
+	return r
+}
+
+// ref refers to the id of the named resource of kind, or, when the configuration does not describe that resource,
+// to the id given
+func (tb *tfBuilder) ref(kind, name, id string) interface{} {
+	if label, ok := tb.labels[kind][name]; ok {
+		return tfExpr(kind + "." + label + ".id")
+	}
+	return id
+}
+
+func (tb *tfBuilder) variable(name string) {
+	for _, v := range tb.variables {
+		if v == name {
+			return
+		}
+	}
+	tb.variables = append(tb.variables, name)
+}
+
+// set adds an attribute to the resource, unless its value is empty
+func (r *tfResource) set(name string, value interface{}) {
+	switch v := value.(type) {
+	case string:
+		if v == "" {
+			return
+		}
+	case []string:
+		if len(v) == 0 {
+			return
+		}
+	}
+	r.attrs = append(r.attrs, tfAttr{name: name, value: value})
+}
+
+func hclValue(v interface{}) string {
+	switch v := v.(type) {
+	case tfExpr:
+		return string(v)
+	case string:
+		return hclString(v)
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = hclString(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// hclString quotes s, escaping the sequences Terraform would otherwise read as templates
+func hclString(s string) string {
+	r := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+		"${", "$${",
+		"%{", "%%{",
+	)
+	return `"` + r.Replace(s) + `"`
+}
+
+// sortedKeys returns the keys of a name-keyed map of a definition, sorted
+func sortedKeys(m interface{}) []string {
+	values := reflect.ValueOf(m).MapKeys()
+	keys := make([]string, len(values))
+	for i, v := range values {
+		keys[i] = v.String()
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,380 @@
+package definition
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// The YAML written by FormatYAML is block style throughout, with strings quoted whenever a plain scalar could be
+// read back as something else.  ParseYAML reads that same subset of YAML: block mappings and sequences, plain,
+// single and double quoted scalars, empty flow collections and comments.  Double quoted scalars use JSON escapes.
+
+var (
+	yamlPlain  = regexp.MustCompile(`^[A-Za-z_/][A-Za-z0-9_./-]*$`)
+	yamlNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+)
+
+// yamlReserved holds the plain scalars which YAML parsers may read as something other than a string
+var yamlReserved = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true, "y": true, "n": true,
+	"null": true, "~": true,
+}
+
+// FormatYAML renders a definition as YAML holding the same document FormatJSON would
+func FormatYAML(zd *ZoneDefinition) ([]byte, error) {
+	if zd == nil {
+		return nil, errors.New("zone definition cannot be empty")
+	}
+	tree, err := definitionTree(zd)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	writeYAMLMapping(buf, tree, 0)
+	return buf.Bytes(), nil
+}
+
+// ParseYAML reconstructs a ZoneDefinition from the output of FormatYAML
+func ParseYAML(b []byte) (*ZoneDefinition, error) {
+	if len(b) == 0 {
+		return nil, errors.New("input cannot be empty")
+	}
+	p := &yamlParser{lines: yamlLines(b)}
+	if len(p.lines) == 0 {
+		return nil, errors.New("input cannot be empty")
+	}
+	v, err := p.parseNode(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected indentation")
+	}
+	tree, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("yaml document is not a mapping")
+	}
+	keys := make([]string, 0, len(tree))
+	for key := range tree {
+		keys = append(keys, key)
+	}
+	if err = checkDefinitionKeys(keys); err != nil {
+		return nil, err
+	}
+	return definitionFromTree(tree)
+}
+
+func writeYAMLMapping(buf *bytes.Buffer, obj map[string]interface{}, indent int) {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for i, k := range keys {
+		// the first key of a mapping within a sequence follows the "- " of its item
+		if i > 0 || buf.Len() == 0 || buf.Bytes()[buf.Len()-1] == '\n' {
+			buf.WriteString(strings.Repeat(" ", indent))
+		}
+		buf.WriteString(yamlScalar(k))
+		buf.WriteString(":")
+		writeYAMLValue(buf, obj[k], indent)
+	}
+}
+
+// writeYAMLValue writes v following the key or "-" it belongs to, which is at indent
+func writeYAMLValue(buf *bytes.Buffer, v interface{}, indent int) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 0 {
+			buf.WriteString(" {}\n")
+			return
+		}
+		buf.WriteString("\n")
+		writeYAMLMapping(buf, v, indent+2)
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString(" []\n")
+			return
+		}
+		buf.WriteString("\n")
+		for _, item := range v {
+			buf.WriteString(strings.Repeat(" ", indent+2))
+			buf.WriteString("-")
+			if obj, ok := item.(map[string]interface{}); ok && len(obj) > 0 {
+				buf.WriteString(" ")
+				writeYAMLMapping(buf, obj, indent+4)
+				continue
+			}
+			writeYAMLValue(buf, item, indent+2)
+		}
+	default:
+		buf.WriteString(" ")
+		buf.WriteString(yamlScalar(v))
+		buf.WriteString("\n")
+	}
+}
+
+func yamlScalar(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case json.Number:
+		return v.String()
+	case string:
+		if yamlPlain.MatchString(v) && !yamlReserved[strings.ToLower(v)] {
+			return v
+		}
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+type yamlLine struct {
+	num     int
+	indent  int
+	content string
+}
+
+// yamlLines splits a document into its significant lines, without comments
+func yamlLines(b []byte) []yamlLine {
+	lines := make([]yamlLine, 0)
+	for i, l := range strings.Split(string(b), "\n") {
+		l = strings.TrimRight(stripYAMLComment(l), " \t\r")
+		content := strings.TrimLeft(l, " ")
+		if content == "" || content == "---" {
+			continue
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(l) - len(content), content: content})
+	}
+	return lines
+}
+
+func stripYAMLComment(l string) string {
+	var quote byte
+	for i := 0; i < len(l); i++ {
+		c := l[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || l[i-1] == ' '):
+			quote = c
+		case c == '#' && (i == 0 || l[i-1] == ' ' || l[i-1] == '\t'):
+			return l[:i]
+		}
+	}
+	return l
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	num := 0
+	if p.pos < len(p.lines) {
+		num = p.lines[p.pos].num
+	} else if len(p.lines) > 0 {
+		num = p.lines[len(p.lines)-1].num
+	}
+	return fmt.Errorf("yaml line %d: %s", num, fmt.Sprintf(format, args...))
+}
+
+func isYAMLItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ")
+}
+
+// parseNode parses the mapping or sequence starting at the current line, which is at indent
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	if isYAMLItem(p.lines[p.pos].content) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	obj := make(map[string]interface{})
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent < indent || isYAMLItem(line.content) {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		key, rest, err := splitYAMLKey(line.content)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		if _, ok := obj[key]; ok {
+			return nil, p.errorf("duplicate key \"%s\"", key)
+		}
+		p.pos++
+		if rest != "" {
+			if obj[key], err = parseYAMLScalar(rest); err != nil {
+				return nil, p.errorf("%s", err)
+			}
+			continue
+		}
+		// a sequence may be indented no further than the key holding it
+		if p.pos < len(p.lines) && (p.lines[p.pos].indent > indent ||
+			(p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].content))) {
+			if obj[key], err = p.parseNode(p.lines[p.pos].indent); err != nil {
+				return nil, err
+			}
+		} else {
+			obj[key] = nil
+		}
+	}
+	return obj, nil
+}
+
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	list := make([]interface{}, 0)
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		if line.indent != indent || !isYAMLItem(line.content) {
+			if line.indent > indent {
+				return nil, p.errorf("unexpected indentation")
+			}
+			break
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.content, "-"), " ")
+		if rest == "" {
+			p.pos++
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				item, err := p.parseNode(p.lines[p.pos].indent)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, item)
+			} else {
+				list = append(list, nil)
+			}
+			continue
+		}
+		if _, _, err := splitYAMLKey(rest); err == nil || isYAMLItem(rest) {
+			// a collection starting on the line of its item is read as though it began on a line of its own
+			p.lines[p.pos] = yamlLine{num: line.num, indent: line.indent + len(line.content) - len(rest), content: rest}
+			item, err := p.parseNode(p.lines[p.pos].indent)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+			continue
+		}
+		item, err := parseYAMLScalar(rest)
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		list = append(list, item)
+		p.pos++
+	}
+	return list, nil
+}
+
+// splitYAMLKey splits a "key: value" line, the value being empty when it follows on later lines
+func splitYAMLKey(content string) (string, string, error) {
+	var key, rest string
+	switch content[0] {
+	case '"', '\'':
+		end := quotedYAMLEnd(content)
+		if end == -1 {
+			return "", "", errors.New("unterminated quoted key")
+		}
+		k, err := parseYAMLScalar(content[:end+1])
+		if err != nil {
+			return "", "", err
+		}
+		key, _ = k.(string)
+		rest = content[end+1:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", errors.New("expected \":\" after key")
+		}
+		rest = rest[1:]
+	default:
+		i := strings.Index(content, ": ")
+		if i == -1 {
+			if !strings.HasSuffix(content, ":") {
+				return "", "", errors.New("expected \"key: value\"")
+			}
+			i = len(content) - 1
+		}
+		key, rest = content[:i], content[i+1:]
+	}
+	if rest != "" && rest[0] != ' ' {
+		return "", "", errors.New("expected a space after \":\"")
+	}
+	return key, strings.TrimSpace(rest), nil
+}
+
+// quotedYAMLEnd returns the index of the quote closing the scalar content starts with
+func quotedYAMLEnd(content string) int {
+	quote := content[0]
+	for i := 1; i < len(content); i++ {
+		switch {
+		case quote == '"' && content[i] == '\\':
+			i++
+		case content[i] == quote:
+			if quote == '\'' && i+1 < len(content) && content[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+func parseYAMLScalar(s string) (interface{}, error) {
+	switch s {
+	case "{}":
+		return map[string]interface{}{}, nil
+	case "[]":
+		return []interface{}{}, nil
+	case "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	switch s[0] {
+	case '"':
+		if quotedYAMLEnd(s) != len(s)-1 {
+			return nil, fmt.Errorf("malformed quoted scalar %s", s)
+		}
+		var str string
+		if err := json.Unmarshal([]byte(s), &str); err != nil {
+			return nil, fmt.Errorf("malformed quoted scalar %s: %s", s, err)
+		}
+		return str, nil
+	case '\'':
+		if quotedYAMLEnd(s) != len(s)-1 {
+			return nil, fmt.Errorf("malformed quoted scalar %s", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case '{', '[', '&', '*', '!', '|', '>':
+		return nil, fmt.Errorf("unsupported yaml construct %s", s)
+	}
+	if yamlNumber.MatchString(s) {
+		return json.Number(s), nil
+	}
+	return s, nil
+}
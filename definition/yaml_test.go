@@ -0,0 +1,114 @@
+package definition
+
+import (
+	"bytes"
+	"github.com/xanzy/go-cloudstack/cloudstack"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestYAMLRoundTrip(t *testing.T) {
+	awkward := []string{"- x", "key: v", "#", "a #b", "yes", "No", "null", "~", "1e5", "0", "-1", "", " padded ",
+		"it's", `"quoted"`, "tab\there", "line\nbreak", "{}", "[a, b]", "*alias", "&anchor", "|", ">", "ünïcode"}
+
+	zd := NewZoneDefinition(cloudstack.Zone{Id: "z1", Name: "zone", Description: strings.Join(awkward, " ")})
+	for i, s := range awkward {
+		name := s + string(rune('a'+i))
+		zd.GlobalConfiguration[name] = cloudstack.Configuration{Name: name, Value: s, Description: s}
+	}
+	zd.Pods["POD-A"] = cloudstack.Pod{Name: "POD-A", Gateway: "10.0.0.1", Startip: "10.0.0.10", Endip: "10.0.0.200"}
+	zd.ComputeOfferings["so"] = cloudstack.ServiceOffering{
+		Name:                   "so",
+		Cpunumber:              2,
+		Offerha:                true,
+		Serviceofferingdetails: map[string]string{"key: v": "- x", "#": "yes"},
+	}
+	zd.PhysicalNetworks["pn 1"] = PhysicalNetwork{
+		PhysicalNetwork: cloudstack.PhysicalNetwork{Name: "pn 1", Vlan: "100-200"},
+		TrafficTypes: map[string]TrafficType{
+			"Guest": {
+				TrafficType: cloudstack.TrafficType{TrafficType: "Guest"},
+				Labels:      TrafficTypeLabels{KVM: "cloudbr0", XenServer: "1e5"},
+				Networks: map[string]cloudstack.Network{
+					"net: a": {Name: "net: a", Cidr: "10.1.0.0/16"},
+				},
+			},
+			"Management": {Labels: TrafficTypeLabels{KVM: "#"}},
+		},
+	}
+	zd.Database = DatabaseConfig{Server: "db", Port: 3306, Password: "p#ss w'rd"}
+
+	b, err := FormatYAML(zd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseYAML(b)
+	if err != nil {
+		t.Fatalf("%s\n%s", err, b)
+	}
+
+	// the round trip must be lossless, compared through JSON as a backup would be
+	want, err := FormatJSON(zd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := FormatJSON(parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Fatalf("round trip differs\nwant: %s\ngot:  %s", want, got)
+	}
+	fromJSON, err := ParseJSON(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromJSON, parsed) {
+		t.Error("definition parsed from yaml differs from the one parsed from json")
+	}
+}
+
+func TestParseYAMLHandWritten(t *testing.T) {
+	b := []byte(`# a comment
+Zone:
+  name: zone  # trailing comment
+  dns1: "8.8.8.8"
+  description: 'it''s'
+Pods:
+  POD-A:
+    name: POD-A
+    endip: 10.0.0.200
+ComputeOfferings: {}
+`)
+	zd, err := ParseYAML(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if zd.Zone.Name != "zone" || zd.Zone.Dns1 != "8.8.8.8" || zd.Zone.Description != "it's" {
+		t.Errorf("unexpected zone %+v", zd.Zone)
+	}
+	if zd.Pods["POD-A"].Endip != "10.0.0.200" {
+		t.Errorf("unexpected pod %+v", zd.Pods["POD-A"])
+	}
+}
+
+func TestParseYAMLUnsupported(t *testing.T) {
+	for _, doc := range []string{
+		"Zone:\n  tags: [a, b]\n",
+		"Zone:\n  description: |\n    text\n",
+		"Zone:\n  name: &a zone\n",
+		"Zone:\n  name: *a\n",
+		"Zone:\n  name: !!str zone\n",
+		"Zone: {name: zone}\n",
+		"Zone:\n  description: >\n    text\n",
+		// documents which are not zone definitions
+		"- Zone\n",
+		"hello: world\n",
+		"Pods:\n  POD-A:\n    name: POD-A\n",
+	} {
+		if _, err := ParseYAML([]byte(doc)); err == nil {
+			t.Errorf("expected an error parsing %q", doc)
+		}
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"github.com/dcarbone/cs-zone-cloner/command"
 	"github.com/dcarbone/cs-zone-cloner/command/backup"
+	"github.com/dcarbone/cs-zone-cloner/command/compare"
+	"github.com/dcarbone/cs-zone-cloner/command/diff"
+	"github.com/dcarbone/cs-zone-cloner/command/drift"
 	"github.com/dcarbone/cs-zone-cloner/command/restore"
 	"github.com/mitchellh/cli"
 	stdlog "log"
@@ -23,6 +26,15 @@ func main() {
 		"restore": func() (cli.Command, error) {
 			return restore.New(os.Args[0], l), nil
 		},
+		"diff": func() (cli.Command, error) {
+			return diff.New(os.Args[0], l), nil
+		},
+		"drift": func() (cli.Command, error) {
+			return drift.New(os.Args[0], l), nil
+		},
+		"compare": func() (cli.Command, error) {
+			return compare.New(os.Args[0], l), nil
+		},
 	}
 
 	status, err := c.Run()